@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//AdapterConfig describes a provider that differs from the others only in its field names, it is one
+//entry of the providers section of the config file
+type AdapterConfig struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	//Request maps each request body field to a template of UserInfo fields such as "{{firstname}} {{lastname}}",
+	//a template that is a single placeholder keeps the field's type so numbers stay numbers
+	Request map[string]string `json:"request"`
+	//Response maps the provider's response to CreditCard fields
+	Response AdapterResponseMapping `json:"response"`
+	//EligibilityScale multiplies the eligibility in the card score, 10 for a 0-10 eligibility
+	//like CSCards sends and 100 for a 0-1 approval rating like ScoredCards sends
+	EligibilityScale float64 `json:"eligibility-scale"`
+}
+
+//AdapterResponseMapping holds the JSON paths of the card fields, such as "cardName" or "offer.apr"
+type AdapterResponseMapping struct {
+	//Cards is the path to the array of cards, empty when the body is the array
+	Cards       string `json:"cards"`
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Apr         string `json:"apr"`
+	Eligibility string `json:"eligibility"`
+	//Features are concatenated in order into the card's features
+	Features []string `json:"features"`
+}
+
+//placeholderPattern matches the UserInfo fields in a request template
+var placeholderPattern = regexp.MustCompile(`{{\s*([^}\s]+)\s*}}`)
+
+//Validate checks the adapter can build a request and find every card field it needs
+func (cfg AdapterConfig) Validate() error {
+	if cfg.Name == "" || cfg.Endpoint == "" {
+		return fmt.Errorf("provider adapters need a name and an endpoint")
+	}
+	if cfg.Name == "CSCards" || cfg.Name == "ScoredCards" {
+		return fmt.Errorf("provider adapter %s clashes with a built-in provider", cfg.Name)
+	}
+	if cfg.Response.Name == "" || cfg.Response.Apr == "" || cfg.Response.Eligibility == "" {
+		return fmt.Errorf("provider adapter %s needs name, apr and eligibility response paths", cfg.Name)
+	}
+	if cfg.EligibilityScale <= 0 {
+		return fmt.Errorf("provider adapter %s needs a positive eligibility-scale", cfg.Name)
+	}
+
+	known := map[string]bool{}
+	for name := range userInfoFields(UserInfo{}) {
+		known[name] = true
+	}
+	for field, template := range cfg.Request {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+			if !known[match[1]] {
+				return fmt.Errorf("provider adapter %s request field %s uses unknown field %s", cfg.Name, field, match[1])
+			}
+		}
+	}
+	return nil
+}
+
+//ConfiguredProviders are the adapters from the config file, Handler asks them after the built-in providers
+var ConfiguredProviders []*AdapterProvider
+
+//AdapterProvider calls a provider described by an AdapterConfig
+type AdapterProvider struct {
+	Config AdapterConfig
+}
+
+//NewAdapterProviders creates a provider for every adapter in the config
+func NewAdapterProviders(configs []AdapterConfig) []*AdapterProvider {
+	var providers []*AdapterProvider
+	for _, cfg := range configs {
+		providers = append(providers, &AdapterProvider{Config: cfg})
+	}
+	return providers
+}
+
+//FindAdapterProvider returns the configured adapter with the given name, or nil
+func FindAdapterProvider(name string) *AdapterProvider {
+	for _, provider := range ConfiguredProviders {
+		if provider.Config.Name == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+//RequestBody fills in the request templates with the applicant's fields
+func (provider *AdapterProvider) RequestBody(userInfo *UserInfo) ([]byte, error) {
+	fields := userInfoFields(*userInfo)
+	body := map[string]interface{}{}
+	for field, template := range provider.Config.Request {
+		match := placeholderPattern.FindStringSubmatch(template)
+		if match != nil && match[0] == template {
+			body[field] = fields[match[1]]
+			continue
+		}
+		body[field] = placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+			value := fields[placeholderPattern.FindStringSubmatch(placeholder)[1]]
+			if number, ok := value.(float64); ok {
+				return strconv.FormatFloat(number, 'f', -1, 64)
+			}
+			return fmt.Sprint(value)
+		})
+	}
+	return json.Marshal(body)
+}
+
+//Fetch sends the applicant to the provider and returns the cards as the provider sent them, an identical call
+//already in flight is shared and the request is cancelled once every caller sharing it is done
+func (provider *AdapterProvider) Fetch(ctx context.Context, userInfo *UserInfo) ([]interface{}, error) {
+	result, err := coalesceFetch(ctx, provider.Config.Name, userInfo, func(ctx context.Context) (interface{}, error) {
+		return provider.fetch(ctx, userInfo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]interface{}), nil
+}
+
+//fetch makes the call to the provider for Fetch
+func (provider *AdapterProvider) fetch(ctx context.Context, userInfo *UserInfo) ([]interface{}, error) {
+	reqBody, err := provider.RequestBody(userInfo)
+	if err != nil {
+		return nil, fmt.Errorf("unable to make a post request due to the incorrect body")
+	}
+
+	req, err := http.NewRequest("POST", provider.Config.Endpoint, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("unable to make a post request due to the incorrect body")
+	}
+	req = req.WithContext(ctx)
+	//sends the request with the provider's shared client, reading at most its max body size
+	resp, body, err := providerClient(provider.Config.Name).post(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+	err = json.Unmarshal(body, &decoded)
+	cards, ok := lookupPath(decoded, provider.Config.Response.Cards)
+	results, isArray := cards.([]interface{})
+	if err != nil || !ok || !isArray {
+		return nil, &ProviderError{
+			Provider:   provider.Config.Name,
+			StatusCode: resp.StatusCode,
+			Body:       ScrubPII(string(body), userInfo),
+			Message:    fmt.Sprintf("unable to reach %s API due to the incorrect body", provider.Config.Name),
+		}
+	}
+	return results, nil
+}
+
+//Score maps the provider's cards to CreditCard structs and calculates their card score,
+//it also returns the eligibility of each card as the provider sent it
+func (provider *AdapterProvider) Score(results []interface{}) ([]CreditCard, []float64) {
+	mapping := provider.Config.Response
+	var creditCardResults []CreditCard
+	var rawScores []float64
+
+	for _, result := range results {
+		apr := lookupNumber(result, mapping.Apr)
+		eligibility := lookupNumber(result, mapping.Eligibility)
+		sc := math.Pow(1/apr, 2)
+		cardScore := math.Floor((eligibility*sc*provider.Config.EligibilityScale)*1000) / 1000
+		//leaves out cards whose score cannot be calculated, such as ones without an APR
+		if !validCardScore(apr, cardScore) {
+			continue
+		}
+
+		//concatenates every feature list into one
+		var features []string
+		for _, path := range mapping.Features {
+			list, _ := lookupPath(result, path)
+			items, _ := list.([]interface{})
+			for _, item := range items {
+				if feature, ok := item.(string); ok {
+					features = append(features, feature)
+				}
+			}
+		}
+
+		creditCardResults = append(creditCardResults, CreditCard{
+			Provider:  provider.Config.Name,
+			Name:      lookupString(result, mapping.Name),
+			ApplyURL:  lookupString(result, mapping.URL),
+			Apr:       apr,
+			Features:  features,
+			CardScore: cardScore,
+		})
+		rawScores = append(rawScores, eligibility)
+	}
+	return creditCardResults, rawScores
+}
+
+//userInfoFields returns the applicant's fields by their JSON name
+func userInfoFields(userInfo UserInfo) map[string]interface{} {
+	body, _ := json.Marshal(userInfo)
+	fields := map[string]interface{}{}
+	json.Unmarshal(body, &fields)
+	return fields
+}
+
+//lookupPath follows a JSON path such as "$.offers[0].apr" through a decoded JSON value,
+//the leading "$." is optional and an empty path returns the value itself
+func lookupPath(value interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return value, true
+	}
+
+	for _, segment := range strings.Split(path, ".") {
+		name := segment
+		var indexes []int
+		if open := strings.Index(segment, "["); open >= 0 {
+			name = segment[:open]
+			for _, part := range strings.Split(strings.TrimSuffix(segment[open+1:], "]"), "][") {
+				index, err := strconv.Atoi(part)
+				if err != nil {
+					return nil, false
+				}
+				indexes = append(indexes, index)
+			}
+		}
+
+		if name != "" {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			value, ok = object[name]
+			if !ok {
+				return nil, false
+			}
+		}
+		for _, index := range indexes {
+			array, ok := value.([]interface{})
+			if !ok || index < 0 || index >= len(array) {
+				return nil, false
+			}
+			value = array[index]
+		}
+	}
+	return value, true
+}
+
+//lookupString returns the string at path, or an empty string
+func lookupString(value interface{}, path string) string {
+	if path == "" {
+		return ""
+	}
+	found, _ := lookupPath(value, path)
+	s, _ := found.(string)
+	return s
+}
+
+//lookupNumber returns the number at path, accepting numbers sent as strings, or 0
+func lookupNumber(value interface{}, path string) float64 {
+	found, _ := lookupPath(value, path)
+	switch n := found.(type) {
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
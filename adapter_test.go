@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+//acmeAdapter describes a provider that nests its cards and names every field differently
+func acmeAdapter(endpoint string) AdapterConfig {
+	return AdapterConfig{
+		Name:     "AcmeCards",
+		Endpoint: endpoint,
+		Request: map[string]string{
+			"applicant": "{{firstname}} {{lastname}}",
+			"born":      "{{dob}}",
+			"rating":    "{{credit-score}}",
+			"income":    "{{salary}}",
+		},
+		Response: AdapterResponseMapping{
+			Cards:       "$.results.cards",
+			Name:        "title",
+			URL:         "links[0]",
+			Apr:         "terms.apr",
+			Eligibility: "likelihood",
+			Features:    []string{"perks", "offers"},
+		},
+		EligibilityScale: 100,
+	}
+}
+
+//TestAdapterProvider checks the request is built from the templates and the response mapped to cards
+func TestAdapterProvider(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//records what the provider was sent
+	var sent map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &sent)
+		fmt.Fprint(w, `{"results": {"cards": [
+			{"title": "Acme Gold", "links": ["http://www.example.com/gold"], "terms": {"apr": "18.9"}, "likelihood": 0.7,
+				"perks": ["Airport lounge access"], "offers": ["0% for 3 months"]},
+			{"title": "Acme Broken", "links": [], "likelihood": 0.9}
+		]}}`)
+	}))
+	defer server.Close()
+
+	provider := &AdapterProvider{Config: acmeAdapter(server.URL)}
+	userInfo := UserInfo{FirstName: "John", LastName: "Smith", DOB: "1991/04/18", CreditScore: 500, EmpStatus: "FULL_TIME", Salary: 1500000}
+	results, err := provider.Fetch(context.Background(), &userInfo)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	//single placeholders keep their type, mixed templates become strings
+	g.Expect(sent).To(gomega.Equal(map[string]interface{}{
+		"applicant": "John Smith",
+		"born":      "1991/04/18",
+		"rating":    float64(500),
+		"income":    float64(1500000),
+	}))
+
+	//the card without an APR is left out
+	cards, rawScores := provider.Score(results)
+	g.Expect(cards).To(gomega.Equal([]CreditCard{
+		{
+			Provider:  "AcmeCards",
+			Name:      "Acme Gold",
+			ApplyURL:  "http://www.example.com/gold",
+			Apr:       18.9,
+			Features:  []string{"Airport lounge access", "0% for 3 months"},
+			CardScore: 0.195,
+		},
+	}))
+	g.Expect(rawScores).To(gomega.Equal([]float64{0.7}))
+}
+
+//TestAdapterMatchesBuiltIn checks an adapter configured like CSCards scores exactly like GetCSCards
+func TestAdapterMatchesBuiltIn(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	var responses []interface{}
+	g.Expect(json.Unmarshal([]byte(csCardsFixture), &responses)).To(gomega.Succeed())
+	var csCards []CSCardResponse
+	g.Expect(json.Unmarshal([]byte(csCardsFixture), &csCards)).To(gomega.Succeed())
+
+	provider := &AdapterProvider{Config: AdapterConfig{
+		Name:             "CSCards",
+		Response:         AdapterResponseMapping{Name: "cardName", URL: "url", Apr: "apr", Eligibility: "eligibility", Features: []string{"features"}},
+		EligibilityScale: 10,
+	}}
+	cards, rawScores := provider.Score(responses)
+	builtInCards, builtInScores := ScoreCSCards(csCards)
+	g.Expect(cards).To(gomega.Equal(builtInCards))
+	g.Expect(rawScores).To(gomega.Equal(builtInScores))
+}
+
+//TestAdapterConfigValidate checks broken adapters are rejected when the config is loaded
+func TestAdapterConfigValidate(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect(acmeAdapter("http://localhost").Validate()).To(gomega.Succeed())
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Change  func(cfg *AdapterConfig)
+		Error   string
+	}{
+		{Message: "should fail as the name clashes with a built-in provider", Change: func(cfg *AdapterConfig) { cfg.Name = "CSCards" },
+			Error: "provider adapter CSCards clashes with a built-in provider"},
+		{Message: "should fail as the apr path is missing", Change: func(cfg *AdapterConfig) { cfg.Response.Apr = "" },
+			Error: "provider adapter AcmeCards needs name, apr and eligibility response paths"},
+		{Message: "should fail as the scale is missing", Change: func(cfg *AdapterConfig) { cfg.EligibilityScale = 0 },
+			Error: "provider adapter AcmeCards needs a positive eligibility-scale"},
+		{Message: "should fail as a template uses an unknown field", Change: func(cfg *AdapterConfig) { cfg.Request["born"] = "{{creditScore}}" },
+			Error: "provider adapter AcmeCards request field born uses unknown field creditScore"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			cfg := acmeAdapter("http://localhost")
+			test.Change(&cfg)
+			err := cfg.Validate()
+			g.Expect(err).To(gomega.HaveOccurred())
+			g.Expect(err.Error()).To(gomega.Equal(test.Error))
+		})
+	}
+}
+
+//TestHandlerWithAdapter checks configured providers are ranked with the built-in ones
+func TestHandlerWithAdapter(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	acme := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"results": {"cards": [{"title": "Acme Gold", "terms": {"apr": 18.9}, "likelihood": 0.7}]}}`)
+	}))
+	defer acme.Close()
+	ConfiguredProviders = NewAdapterProviders([]AdapterConfig{acmeAdapter(acme.URL)})
+	defer func() { ConfiguredProviders = nil }()
+
+	reqBody := []byte(`{"firstname": "John", "lastname": "Smith", "dob": "1991/04/18", "credit-score": 500, "employment-status": "FULL_TIME", "salary": 30000}`)
+	rr := httptest.NewRecorder()
+	Handler(DecodeConfig{})(rr, httptest.NewRequest(http.MethodPost, "/v1/creditcard", bytes.NewReader(reqBody)))
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusOK))
+
+	var creditcards []CreditCard
+	g.Expect(json.Unmarshal(rr.Body.Bytes(), &creditcards)).To(gomega.Succeed())
+	g.Expect(creditcards).To(gomega.HaveLen(4))
+	g.Expect(creditcards[0].Name).To(gomega.Equal("ScoredCard Builder"))
+	g.Expect(creditcards[1].Name).To(gomega.Equal("Acme Gold"))
+}
+
+//FuzzAdapterScore checks any response body either fails to map or scores to rankable cards
+func FuzzAdapterScore(f *testing.F) {
+	f.Add([]byte(`{"results": {"cards": [{"title": "Acme Gold", "links": ["http://www.example.com/gold"], "terms": {"apr": "18.9"}, "likelihood": 0.7, "perks": ["Airport lounge access"]}]}}`))
+	f.Add([]byte(`{"results": {"cards": [{"terms": {"apr": "0"}}, {"terms": {"apr": "NaN"}, "likelihood": 1}, {"terms": {"apr": 1e-300}, "likelihood": 1e300}]}}`))
+	f.Add([]byte(`{"results": {"cards": {"title": "not a list"}}}`))
+	provider := &AdapterProvider{Config: acmeAdapter("http://localhost")}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		var decoded interface{}
+		if json.Unmarshal(body, &decoded) != nil {
+			return
+		}
+		cards, _ := lookupPath(decoded, provider.Config.Response.Cards)
+		results, ok := cards.([]interface{})
+		if !ok {
+			return
+		}
+		creditcards, rawScores := provider.Score(results)
+		expectRankable(t, creditcards, rawScores)
+	})
+}
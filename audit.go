@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//AuditConfig is the audit section of the config file
+type AuditConfig struct {
+	//Path is the JSONL file entries are appended to, auditing is off when it is empty
+	Path string `json:"path"`
+	//MaxBytes rotates the file once it would grow past this size, 0 never rotates
+	MaxBytes int64 `json:"max-bytes"`
+	//Fsync is "always" to sync the file after every entry or "never" to leave it to the OS
+	Fsync string `json:"fsync"`
+	//PseudonymKey keys the applicant hash so it cannot be reversed by hashing guessed names and birth dates
+	PseudonymKey string `json:"pseudonym-key"`
+}
+
+//Validate checks the audit file can be written and applicants pseudonymized
+func (cfg AuditConfig) Validate() error {
+	if cfg.Path == "" {
+		return nil
+	}
+	if cfg.Fsync != "always" && cfg.Fsync != "never" {
+		return fmt.Errorf("audit fsync must be always or never")
+	}
+	if cfg.PseudonymKey == "" {
+		return fmt.Errorf("audit needs a pseudonym-key to hash applicants")
+	}
+	return nil
+}
+
+//AuditEntry is one recommendation decision as written to the audit log
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request-id"`
+	//ClientRequestID is the X-Request-ID the client sent, it is not checked so it is kept apart from RequestID
+	ClientRequestID string          `json:"client-request-id,omitempty"`
+	ApplicantHash   string          `json:"applicant-hash"`
+	ScoringVersion  string          `json:"scoring-version"`
+	Providers       []AuditProvider `json:"providers"`
+	Ranked          []CreditCard    `json:"ranked"`
+}
+
+//AuditProvider is what one provider was asked and what it answered
+type AuditProvider struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+	//Response is the provider's cards as they were decoded, before scoring
+	Response json.RawMessage `json:"response,omitempty"`
+	Scores   []AuditScore    `json:"scores,omitempty"`
+}
+
+//AuditScore is the raw score a provider gave a card next to the card score calculated from it
+type AuditScore struct {
+	Card      string  `json:"card"`
+	Apr       float64 `json:"apr"`
+	RawScore  float64 `json:"raw-score"`
+	CardScore float64 `json:"card-score"`
+}
+
+//AuditSink is where audit entries are written, implement it to ship entries somewhere other than a file
+type AuditSink interface {
+	Write(entry *AuditEntry) error
+	Close() error
+}
+
+//Auditor pseudonymizes applicants and writes their recommendations to a sink
+type Auditor struct {
+	Sink         AuditSink
+	PseudonymKey []byte
+}
+
+//DefaultAuditor records every recommendation made by Handler, it is nil when auditing is off
+var DefaultAuditor *Auditor
+
+//NewAuditor creates an auditor writing to the file in the config, or nil when auditing is off
+func NewAuditor(cfg AuditConfig) (*Auditor, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	sink, err := NewFileAuditSink(cfg.Path, cfg.MaxBytes, cfg.Fsync == "always")
+	if err != nil {
+		return nil, err
+	}
+	return &Auditor{Sink: sink, PseudonymKey: []byte(cfg.PseudonymKey)}, nil
+}
+
+//ApplicantHash pseudonymizes an applicant, the same person always gets the same hash under the same key
+func (auditor *Auditor) ApplicantHash(userInfo UserInfo) string {
+	mac := hmac.New(sha256.New, auditor.PseudonymKey)
+	normalized := strings.ToLower(strings.TrimSpace(userInfo.FirstName) + "|" + strings.TrimSpace(userInfo.LastName) + "|" + strings.TrimSpace(userInfo.DOB))
+	mac.Write([]byte(normalized))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//Record writes the entry, a failed write is logged rather than failing the customer's request
+func (auditor *Auditor) Record(entry *AuditEntry) {
+	err := auditor.Sink.Write(entry)
+	if err != nil {
+		log.Printf("audit entry for request %s was not written: %v", entry.RequestID, err)
+	}
+}
+
+//NewAuditProvider builds the audit record of one provider call from its raw response and scored cards
+func NewAuditProvider(name string, response interface{}, cards []CreditCard, rawScores []float64, err error) AuditProvider {
+	provider := AuditProvider{Name: name}
+	if err != nil {
+		provider.Error = err.Error()
+		return provider
+	}
+	provider.Response, _ = json.Marshal(response)
+	for i, card := range cards {
+		provider.Scores = append(provider.Scores, AuditScore{
+			Card:      card.Name,
+			Apr:       card.Apr,
+			RawScore:  rawScores[i],
+			CardScore: card.CardScore,
+		})
+	}
+	return provider
+}
+
+//maxClientRequestIDLength is the most of a client's X-Request-ID kept in the audit log
+const maxClientRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+//requestIDs are the ID the server gave a request and the one its client sent
+type requestIDs struct {
+	id     string
+	client string
+}
+
+//RequestIDs is the middleware that gives every request an ID of the server's own, so callers cannot forge or collide
+//the IDs in the audit log. The X-Request-ID sent by the client or the Heroku router is kept apart, cut down to
+//printable characters and a bounded length, and the server's ID is answered in X-Request-ID
+func RequestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids := &requestIDs{id: newRequestID(), client: cleanRequestID(r.Header.Get("X-Request-ID"))}
+		w.Header().Set("X-Request-ID", ids.id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, ids)))
+	})
+}
+
+//RequestID returns the ID RequestIDs gave the request, or a new one for a request that did not go through it
+func RequestID(r *http.Request) string {
+	if ids, ok := r.Context().Value(requestIDContextKey{}).(*requestIDs); ok {
+		return ids.id
+	}
+	return newRequestID()
+}
+
+//ClientRequestID returns the X-Request-ID the client sent with the request ctx belongs to, empty when it sent none
+func ClientRequestID(ctx context.Context) string {
+	if ids, ok := ctx.Value(requestIDContextKey{}).(*requestIDs); ok {
+		return ids.client
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//cleanRequestID keeps the printable ASCII of a client's request ID, up to maxClientRequestIDLength characters
+func cleanRequestID(id string) string {
+	var cleaned strings.Builder
+	for i := 0; i < len(id) && cleaned.Len() < maxClientRequestIDLength; i++ {
+		if id[i] >= ' ' && id[i] <= '~' {
+			cleaned.WriteByte(id[i])
+		}
+	}
+	return cleaned.String()
+}
+
+//FileAuditSink appends entries as JSON lines to a file and rotates it by size
+type FileAuditSink struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	fsync    bool
+	file     *os.File
+	size     int64
+	//rename moves the full file aside, it is os.Rename outside tests
+	rename func(oldpath, newpath string) error
+}
+
+//NewFileAuditSink opens the audit file for appending, creating it if needed
+func NewFileAuditSink(path string, maxBytes int64, fsync bool) (*FileAuditSink, error) {
+	sink := &FileAuditSink{path: path, maxBytes: maxBytes, fsync: fsync, rename: os.Rename}
+	err := sink.open()
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (sink *FileAuditSink) open() error {
+	file, err := os.OpenFile(sink.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open audit file %s", sink.path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("unable to stat audit file %s", sink.path)
+	}
+	sink.file = file
+	sink.size = info.Size()
+	return nil
+}
+
+//Write appends the entry as one line, rotating first if the line would take the file past its maximum size
+func (sink *FileAuditSink) Write(entry *AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	//a file that could not be opened again after a rotation is retried on every write
+	if sink.file == nil {
+		err = sink.open()
+		if err != nil {
+			return err
+		}
+	}
+	if sink.maxBytes > 0 && sink.size > 0 && sink.size+int64(len(line)) > sink.maxBytes {
+		err = sink.rotate()
+		//a file that cannot be rotated keeps growing rather than losing entries, rotation is tried again next time
+		if err != nil && sink.file == nil {
+			return err
+		}
+		if err != nil {
+			log.Printf("%v, appending to it instead", err)
+		}
+	}
+
+	n, err := sink.file.Write(line)
+	sink.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if sink.fsync {
+		return sink.file.Sync()
+	}
+	return nil
+}
+
+//rotate renames the current file with a timestamp suffix and starts a new one, rotated files are never modified.
+//When the rename fails the current file is opened again, sink.file is only left nil when that fails too
+func (sink *FileAuditSink) rotate() error {
+	err := sink.file.Close()
+	sink.file = nil
+	if err != nil {
+		return sink.reopen(fmt.Errorf("unable to close audit file %s: %v", sink.path, err))
+	}
+	rotated := fmt.Sprintf("%s.%s", sink.path, time.Now().UTC().Format("20060102T150405.000000000"))
+	err = sink.rename(sink.path, rotated)
+	if err != nil {
+		return sink.reopen(fmt.Errorf("unable to rotate audit file %s: %v", sink.path, err))
+	}
+	return sink.open()
+}
+
+//reopen opens the audit file again for appending after a failed rotation and returns the rotation's error
+func (sink *FileAuditSink) reopen(rotateErr error) error {
+	err := sink.open()
+	if err != nil {
+		return fmt.Errorf("%v, and %v", rotateErr, err)
+	}
+	return rotateErr
+}
+
+//Close syncs and closes the audit file
+func (sink *FileAuditSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	err := sink.file.Sync()
+	if err != nil {
+		return err
+	}
+	return sink.file.Close()
+}
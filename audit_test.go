@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+//memoryAuditSink keeps entries in memory for tests
+type memoryAuditSink struct {
+	entries []*AuditEntry
+}
+
+func (sink *memoryAuditSink) Write(entry *AuditEntry) error {
+	sink.entries = append(sink.entries, entry)
+	return nil
+}
+
+func (sink *memoryAuditSink) Close() error {
+	return nil
+}
+
+//TestHandlerAudit checks the handler records the providers, raw scores and ranking of a recommendation
+func TestHandlerAudit(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	sink := &memoryAuditSink{}
+	DefaultAuditor = &Auditor{Sink: sink, PseudonymKey: []byte("test-key")}
+	defer func() { DefaultAuditor = nil }()
+
+	reqBody := []byte(`{"firstname": "John", "lastname": "Smith", "dob": "1991/04/18", "credit-score": 500, "employment-status": "FULL_TIME", "salary": 30000}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/creditcard", bytes.NewReader(reqBody))
+	req.Header.Set("X-Request-ID", "req-1\n"+strings.Repeat("x", 200))
+	rr := httptest.NewRecorder()
+	RequestIDs(Handler(DecodeConfig{})).ServeHTTP(rr, req)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusOK))
+
+	g.Expect(sink.entries).To(gomega.HaveLen(1))
+	entry := sink.entries[0]
+	//the entry has the server's ID and the client's, cleaned up and cut short
+	g.Expect(entry.RequestID).To(gomega.MatchRegexp("^[0-9a-f]{32}$"))
+	g.Expect(rr.Header().Get("X-Request-ID")).To(gomega.Equal(entry.RequestID))
+	g.Expect(entry.ClientRequestID).To(gomega.Equal("req-1" + strings.Repeat("x", 123)))
+	g.Expect(entry.ScoringVersion).To(gomega.Equal(ScoringVersion))
+	g.Expect(entry.ApplicantHash).To(gomega.Equal(DefaultAuditor.ApplicantHash(UserInfo{FirstName: " john", LastName: "SMITH", DOB: "1991/04/18"})))
+	g.Expect(entry.Providers).To(gomega.HaveLen(2))
+	g.Expect(entry.Providers[0].Name).To(gomega.Equal("CSCards"))
+	g.Expect(entry.Providers[0].Scores).To(gomega.Equal([]AuditScore{
+		{Card: "SuperSaver Card", Apr: 21.4, RawScore: 6.3, CardScore: 0.137},
+		{Card: "SuperSpender Card", Apr: 19.2, RawScore: 5.0, CardScore: 0.135},
+	}))
+	g.Expect(entry.Providers[1].Scores).To(gomega.Equal([]AuditScore{
+		{Card: "ScoredCard Builder", Apr: 19.4, RawScore: 0.8, CardScore: 0.212},
+	}))
+	g.Expect(entry.Ranked).To(gomega.HaveLen(3))
+	g.Expect(entry.Ranked[0].Name).To(gomega.Equal("ScoredCard Builder"))
+
+	//the entry holds no personal data
+	line, _ := json.Marshal(entry)
+	for _, value := range []string{"John", "Smith", "1991/04/18", "30000"} {
+		g.Expect(string(line)).NotTo(gomega.ContainSubstring(value))
+	}
+}
+
+//TestFileAuditSink checks entries are appended as JSON lines and the file rotates by size
+func TestFileAuditSink(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "audit")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "requests.jsonl")
+
+	entry := &AuditEntry{RequestID: "req-1", ScoringVersion: ScoringVersion}
+	line, _ := json.Marshal(entry)
+	//fits two entries per file
+	sink, err := NewFileAuditSink(path, int64(len(line)+1)*2, true)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	for i := 0; i < 3; i++ {
+		g.Expect(sink.Write(entry)).To(gomega.Succeed())
+	}
+	g.Expect(sink.Close()).To(gomega.Succeed())
+
+	//the first two entries were rotated out and the third started a new file
+	rotated, _ := filepath.Glob(path + ".*")
+	g.Expect(rotated).To(gomega.HaveLen(1))
+	g.Expect(countLines(t, rotated[0])).To(gomega.Equal(2))
+	g.Expect(countLines(t, path)).To(gomega.Equal(1))
+
+	//reopening appends to the existing file
+	sink, err = NewFileAuditSink(path, 0, false)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(sink.Write(entry)).To(gomega.Succeed())
+	g.Expect(sink.Close()).To(gomega.Succeed())
+	g.Expect(countLines(t, path)).To(gomega.Equal(2))
+}
+
+//TestFileAuditSinkRotateFailure checks entries are still appended when the file cannot be rotated
+func TestFileAuditSinkRotateFailure(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "audit")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "requests.jsonl")
+
+	entry := &AuditEntry{RequestID: "req-1", ScoringVersion: ScoringVersion}
+	line, _ := json.Marshal(entry)
+	//fits one entry per file
+	sink, err := NewFileAuditSink(path, int64(len(line)+1), false)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	sink.rename = func(oldpath, newpath string) error {
+		return fmt.Errorf("rename %s: permission denied", oldpath)
+	}
+
+	//the rotations fail so every entry stays in the current file
+	for i := 0; i < 3; i++ {
+		g.Expect(sink.Write(entry)).To(gomega.Succeed())
+	}
+	rotated, _ := filepath.Glob(path + ".*")
+	g.Expect(rotated).To(gomega.BeEmpty())
+	g.Expect(countLines(t, path)).To(gomega.Equal(3))
+
+	//once renaming works again the full file is rotated out
+	sink.rename = os.Rename
+	g.Expect(sink.Write(entry)).To(gomega.Succeed())
+	g.Expect(sink.Close()).To(gomega.Succeed())
+	rotated, _ = filepath.Glob(path + ".*")
+	g.Expect(rotated).To(gomega.HaveLen(1))
+	g.Expect(countLines(t, rotated[0])).To(gomega.Equal(3))
+	g.Expect(countLines(t, path)).To(gomega.Equal(1))
+}
+
+//countLines counts the JSON lines in a file, failing if any of them is not valid JSON
+func countLines(t *testing.T, path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	return count
+}
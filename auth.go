@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/heroku/go-getting-started/client"
+)
+
+//AuthConfig is the auth section of the config file
+type AuthConfig struct {
+	Enabled bool `json:"enabled"`
+	//KeysFile is a JSON array of APIKey entries, it is re-read on SIGHUP and POST /v1/admin/keys/reload
+	KeysFile string   `json:"keys-file"`
+	Keys     []APIKey `json:"keys"`
+}
+
+//APIKey is a hashed API key with the metadata of the client it belongs to
+type APIKey struct {
+	//Hash is the hex SHA-256 of the key, the key itself is never stored
+	Hash   string `json:"hash"`
+	Client string `json:"client"`
+	Tier   string `json:"tier"`
+	//Routes are the route templates the key may call, "*" allows every route
+	Routes []string `json:"routes"`
+	//ExpiresAt lets an old key keep working for a while after it has been rotated
+	ExpiresAt *time.Time `json:"expires-at,omitempty"`
+}
+
+//Client is the identity of the caller, attached to the request context once authenticated
+type Client struct {
+	Name string
+	Tier string
+	//Method is how the client authenticated, "api-key" or "jwt"
+	Method string
+	//KeyID identifies the credential used without revealing it
+	KeyID string
+}
+
+type clientContextKey struct{}
+
+//WithClient returns a copy of ctx carrying the client identity
+func WithClient(ctx context.Context, client *Client) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, client)
+}
+
+//ClientFromContext returns the client identity attached by the auth middleware, or nil
+func ClientFromContext(ctx context.Context) *Client {
+	client, _ := ctx.Value(clientContextKey{}).(*Client)
+	return client
+}
+
+//HashAPIKey returns the hash an API key is stored under
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+//KeyStore holds the API keys and swaps them atomically on reload
+type KeyStore struct {
+	mu     sync.RWMutex
+	keys   map[string]APIKey
+	config AuthConfig
+	now    func() time.Time
+}
+
+//NewKeyStore loads the keys from the config and keys file
+func NewKeyStore(cfg AuthConfig) (*KeyStore, error) {
+	store := &KeyStore{config: cfg, now: time.Now}
+	err := store.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+//Reload re-reads the keys file, the old keys stay in place if it cannot be read
+func (store *KeyStore) Reload() error {
+	keys := map[string]APIKey{}
+	entries := append([]APIKey{}, store.config.Keys...)
+
+	if store.config.KeysFile != "" {
+		body, err := ioutil.ReadFile(store.config.KeysFile)
+		if err != nil {
+			return fmt.Errorf("unable to read keys file %s", store.config.KeysFile)
+		}
+		var fileKeys []APIKey
+		err = json.Unmarshal(body, &fileKeys)
+		if err != nil {
+			return fmt.Errorf("unable to parse keys file %s: %v", store.config.KeysFile, err)
+		}
+		entries = append(entries, fileKeys...)
+	}
+
+	for _, key := range entries {
+		if len(key.Hash) != sha256.Size*2 || key.Client == "" {
+			return fmt.Errorf("api key for client %q needs a client name and a hex SHA-256 hash", key.Client)
+		}
+		keys[key.Hash] = key
+	}
+
+	store.mu.Lock()
+	store.keys = keys
+	store.mu.Unlock()
+	return nil
+}
+
+//Lookup finds the unexpired key matching the raw key sent by a client
+func (store *KeyStore) Lookup(rawKey string) (APIKey, bool) {
+	hash := HashAPIKey(rawKey)
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	for stored, key := range store.keys {
+		//compares every hash in constant time so the lookup does not leak how much of a hash matched
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			if key.ExpiresAt != nil && store.now().After(*key.ExpiresAt) {
+				return APIKey{}, false
+			}
+			return key, true
+		}
+	}
+	return APIKey{}, false
+}
+
+//ReloadOnSignal reloads the keys every time the process receives SIGHUP, until stop is called
+func (store *KeyStore) ReloadOnSignal() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+			}
+			err := store.Reload()
+			if err != nil {
+				log.Printf("api keys were not reloaded: %v", err)
+				continue
+			}
+			log.Printf("api keys reloaded")
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+//ReloadHandler reloads the keys on request, so keys can be rotated without a restart
+func (store *KeyStore) ReloadHandler(w http.ResponseWriter, r *http.Request) {
+	err := store.Reload()
+	if err != nil {
+		writeProblem(w, r, client.ProblemInternal, 500, "unable to reload api keys")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//allows reports whether the key may call the route with the given template, "*" allows every route but the admin ones,
+//which a key has to name
+func (key APIKey) allows(route string) bool {
+	for _, allowed := range key.Routes {
+		if allowed == route || (allowed == "*" && !adminRoute(route)) {
+			return true
+		}
+	}
+	return false
+}
+
+//adminRoute reports whether the route template is one of the admin routes, they are only served when clients authenticate
+func adminRoute(route string) bool {
+	return strings.HasPrefix(route, "/v1/admin/")
+}
+
+//APIKeyAuth is the middleware that authenticates clients by their X-API-Key header
+type APIKeyAuth struct {
+	Enabled bool
+	Store   *KeyStore
+}
+
+//Middleware rejects requests without a valid key for the route and attaches the client to the context
+func (auth *APIKeyAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.Enabled || ClientFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rawKey := r.Header.Get("X-API-Key")
+		if rawKey == "" {
+			writeProblem(w, r, client.ProblemUnauthorized, http.StatusUnauthorized, "please provide an api key")
+			return
+		}
+		key, ok := auth.Store.Lookup(rawKey)
+		if !ok {
+			writeProblem(w, r, client.ProblemUnauthorized, http.StatusUnauthorized, "invalid api key")
+			return
+		}
+		if !key.allows(routeTemplate(r)) {
+			writeProblem(w, r, client.ProblemForbidden, http.StatusForbidden, "api key is not allowed to call this route")
+			return
+		}
+
+		client := &Client{
+			Name:   key.Client,
+			Tier:   key.Tier,
+			Method: "api-key",
+			KeyID:  key.Hash[:8],
+		}
+		next.ServeHTTP(w, r.WithContext(WithClient(r.Context(), client)))
+	})
+}
+
+//routeTemplate returns the template of the matched route, or the raw path outside the router
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
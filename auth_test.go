@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/onsi/gomega"
+)
+
+//TestAPIKeyAuth checks keys are checked against their hash and allowed routes
+func TestAPIKeyAuth(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	expired := time.Now().Add(-time.Hour)
+	store, err := NewKeyStore(AuthConfig{
+		Keys: []APIKey{
+			{Hash: HashAPIKey("partner-key"), Client: "partner", Tier: "gold", Routes: []string{"/v1/creditcard"}},
+			{Hash: HashAPIKey("old-key"), Client: "partner", Tier: "gold", Routes: []string{"*"}, ExpiresAt: &expired},
+			{Hash: HashAPIKey("any-key"), Client: "billing", Tier: "gold", Routes: []string{"*"}},
+			{Hash: HashAPIKey("admin-key"), Client: "ops", Tier: "gold", Routes: []string{"/v1/admin/keys/reload"}},
+		},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	//records the client the handler sees
+	var seen *Client
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}
+	r.HandleFunc("/v1/creditcard", ok)
+	r.HandleFunc("/v1/admin/keys/reload", ok)
+	r.Use((&APIKeyAuth{Enabled: true, Store: store}).Middleware)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Path    string
+		Key     string
+		Status  int
+	}{
+		{Message: "should fail as there is no key", Path: "/v1/creditcard", Key: "", Status: http.StatusUnauthorized},
+		{Message: "should fail as the key is unknown", Path: "/v1/creditcard", Key: "guess", Status: http.StatusUnauthorized},
+		{Message: "should fail as the key has expired", Path: "/v1/creditcard", Key: "old-key", Status: http.StatusUnauthorized},
+		{Message: "should fail as the key is not allowed on the route", Path: "/v1/admin/keys/reload", Key: "partner-key", Status: http.StatusForbidden},
+		{Message: "should fail as every route does not include the admin routes", Path: "/v1/admin/keys/reload", Key: "any-key", Status: http.StatusForbidden},
+		{Message: "should not fail as the key names the admin route", Path: "/v1/admin/keys/reload", Key: "admin-key", Status: http.StatusOK},
+		{Message: "should not fail as the key is allowed on the route", Path: "/v1/creditcard", Key: "partner-key", Status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest(http.MethodPost, test.Path, nil)
+			if test.Key != "" {
+				req.Header.Set("X-API-Key", test.Key)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			g.Expect(rr.Code).To(gomega.Equal(test.Status))
+		})
+	}
+
+	//the last request attached the client identity without the raw key
+	g.Expect(seen).To(gomega.Equal(&Client{Name: "partner", Tier: "gold", Method: "api-key", KeyID: HashAPIKey("partner-key")[:8]}))
+}
+
+//TestKeyStoreReload checks keys can be rotated by rewriting the keys file
+func TestKeyStoreReload(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "keys")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	keysFile := filepath.Join(dir, "keys.json")
+
+	writeKeys := func(key string) {
+		body := `[{"hash": "` + HashAPIKey(key) + `", "client": "partner", "tier": "standard", "routes": ["*"]}]`
+		g.Expect(ioutil.WriteFile(keysFile, []byte(body), 0600)).To(gomega.Succeed())
+	}
+
+	writeKeys("first")
+	store, err := NewKeyStore(AuthConfig{KeysFile: keysFile})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	_, ok := store.Lookup("first")
+	g.Expect(ok).To(gomega.BeTrue())
+
+	//rotates the key and reloads through the admin handler
+	writeKeys("second")
+	rr := httptest.NewRecorder()
+	store.ReloadHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/admin/keys/reload", nil))
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusNoContent))
+
+	_, ok = store.Lookup("first")
+	g.Expect(ok).To(gomega.BeFalse())
+	_, ok = store.Lookup("second")
+	g.Expect(ok).To(gomega.BeTrue())
+
+	//a broken keys file leaves the current keys in place
+	g.Expect(ioutil.WriteFile(keysFile, []byte("not json"), 0600)).To(gomega.Succeed())
+	g.Expect(store.Reload()).NotTo(gomega.Succeed())
+	_, ok = store.Lookup("second")
+	g.Expect(ok).To(gomega.BeTrue())
+}
+
+//TestAdminRoutes checks the admin routes are only served when clients have to authenticate
+func TestAdminRoutes(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Auth    AuthConfig
+		Key     string
+		Status  int
+	}{
+		{Message: "should not be found as authentication is off", Status: http.StatusNotFound},
+		{Message: "should fail as the key has no admin grant", Key: "any-key", Status: http.StatusForbidden,
+			Auth: AuthConfig{Enabled: true, Keys: []APIKey{{Hash: HashAPIKey("any-key"), Client: "billing", Routes: []string{"*"}}}}},
+		{Message: "should not fail as the key is granted the admin route", Key: "admin-key", Status: http.StatusOK,
+			Auth: AuthConfig{Enabled: true, Keys: []APIKey{{Hash: HashAPIKey("admin-key"), Client: "ops", Routes: []string{"/v1/admin/metrics"}}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Auth = test.Auth
+			router, stop, err := NewRouter(cfg)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			defer stop()
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/admin/metrics", nil)
+			req.Header.Set("X-API-Key", test.Key)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			g.Expect(rr.Code).To(gomega.Equal(test.Status))
+		})
+	}
+}
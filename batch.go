@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/heroku/go-getting-started/client"
+)
+
+//NDJSONContentType is the media type of newline delimited JSON, batches may be sent and are always answered in it
+const NDJSONContentType = "application/x-ndjson"
+
+//BatchConfig is the batch section of the config file
+type BatchConfig struct {
+	//Concurrency is how many applicants of a batch are recommended at once, MaxItems is the most applicants a batch may hold
+	Concurrency int `json:"concurrency"`
+	MaxItems    int `json:"max-items"`
+	//MaxBodyBytes is the largest batch accepted, a larger one is refused with 413 before it is split
+	MaxBodyBytes int64 `json:"max-body-bytes"`
+	//ProviderLimits caps the requests sent to each provider, every request shares the buckets so a batch cannot use up a partner's quota
+	ProviderLimits map[string]RateLimitTier `json:"provider-limits"`
+}
+
+//Validate checks the batch limits are usable
+func (cfg BatchConfig) Validate() error {
+	if cfg.Concurrency < 1 || cfg.MaxItems < 1 || cfg.MaxBodyBytes < 1 {
+		return fmt.Errorf("batch needs a positive concurrency, max-items and max-body-bytes")
+	}
+	for provider, tier := range cfg.ProviderLimits {
+		if tier.RequestsPerMinute <= 0 || tier.Burst < 1 {
+			return fmt.Errorf("batch provider limit %q needs a positive requests-per-minute and burst", provider)
+		}
+	}
+	return nil
+}
+
+//Batch recommends cards for many applicants in one request
+type Batch struct {
+	Config BatchConfig
+	//Decoding says whether records may hold unknown fields, the batch has its own body limit
+	Decoding DecodeConfig
+}
+
+//Handler reads a JSON array or NDJSON stream of applicants and streams back one BatchResult per applicant as NDJSON,
+//results are written as they finish so they carry the applicant's index, a bad record only fails its own result
+func (batch *Batch) Handler(w http.ResponseWriter, r *http.Request) {
+	//reads the whole batch first as HTTP/1 handlers cannot read the body once the response has started
+	body, err := readBody(r, batch.Config.MaxBodyBytes, "application/json", NDJSONContentType)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	records, err := splitBatch(body)
+	if err != nil {
+		writeProblem(w, r, client.ProblemInvalidRequest, 400, "%v", err)
+		return
+	}
+	if len(records) == 0 {
+		writeProblem(w, r, client.ProblemInvalidRequest, 400, "please enter at least one applicant")
+		return
+	}
+	if len(records) > batch.Config.MaxItems {
+		writeProblem(w, r, client.ProblemPayloadTooLarge, http.StatusRequestEntityTooLarge, "a batch may hold at most %d applicants, got %d", batch.Config.MaxItems, len(records))
+		return
+	}
+
+	w.Header().Set("Content-Type", NDJSONContentType)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	//workers take indexes from the queue, results are written one at a time by this goroutine
+	ctx := r.Context()
+	requestID := RequestID(r)
+	queue := make(chan int)
+	results := make(chan client.BatchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < batch.Config.Concurrency && i < len(records); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range queue {
+				results <- recommendRecord(ctx, index, records[index], requestID+"-"+strconv.Itoa(index), batch.Decoding.DisallowUnknownFields)
+			}
+		}()
+	}
+	go func() {
+		for index := range records {
+			queue <- index
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	encoder := json.NewEncoder(w)
+	for result := range results {
+		encoder.Encode(result)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+//recommendRecord decodes one applicant of a batch and recommends cards for it
+func recommendRecord(ctx context.Context, index int, record json.RawMessage, requestID string, disallowUnknownFields bool) client.BatchResult {
+	var userInfo UserInfo
+	err := decodeJSON(record, &userInfo, disallowUnknownFields)
+	if err != nil {
+		problem := client.NewProblem(client.ProblemInvalidRequest, 400, err.Error())
+		return client.BatchResult{Index: index, Status: problem.Status, Problem: problem}
+	}
+
+	if ctx.Err() != nil {
+		return batchTimeout(index)
+	}
+	cards, err := Recommend(ctx, userInfo, requestID)
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return batchTimeout(index)
+	}
+	if err != nil {
+		problem := client.NewProblem(client.ProblemProviderUnavailable, 400, err.Error())
+		if recommendErr, ok := err.(*RecommendError); ok {
+			problem.Provider = recommendErr.Provider
+		}
+		return client.BatchResult{Index: index, Status: problem.Status, Problem: problem}
+	}
+	if cards == nil {
+		cards = []CreditCard{}
+	}
+	return client.BatchResult{Index: index, Status: http.StatusOK, Cards: cards}
+}
+
+//batchTimeout is the result of an applicant the batch ran out of time for
+func batchTimeout(index int) client.BatchResult {
+	problem := client.NewProblem(client.ProblemTimeout, http.StatusGatewayTimeout, "the batch ran out of time before this applicant was recommended")
+	return client.BatchResult{Index: index, Status: problem.Status, Problem: problem}
+}
+
+//splitBatch splits a JSON array into its elements, or NDJSON into its non-blank lines,
+//records are not decoded here so a malformed line only fails that applicant
+func splitBatch(body []byte) ([]json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var records []json.RawMessage
+		err := json.Unmarshal(trimmed, &records)
+		if err != nil {
+			return nil, fmt.Errorf("please enter the batch as a JSON array or one JSON object per line")
+		}
+		return records, nil
+	}
+
+	var records []json.RawMessage
+	for _, line := range bytes.Split(trimmed, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			records = append(records, json.RawMessage(line))
+		}
+	}
+	return records, nil
+}
+
+//ProviderThrottle is a transport that keeps the requests to each provider under its limit,
+//requests wait for a token instead of failing so batches slow down to the rate the partners allow
+type ProviderThrottle struct {
+	Limits map[string]RateLimitTier
+	Store  RateLimitStore
+	Next   http.RoundTripper
+	Now    func() time.Time
+}
+
+//NewProviderThrottle creates a throttle backed by the in-memory store, nil next uses ProviderBaseTransport
+func NewProviderThrottle(limits map[string]RateLimitTier, next http.RoundTripper) *ProviderThrottle {
+	return &ProviderThrottle{Limits: limits, Store: NewMemoryRateLimitStore(), Next: next, Now: time.Now}
+}
+
+//RoundTrip waits for a token from the provider's bucket, providers without a limit are not throttled
+func (throttle *ProviderThrottle) RoundTrip(req *http.Request) (*http.Response, error) {
+	provider := providerForURL(req.URL)
+	if tier, ok := throttle.Limits[provider]; ok {
+		for {
+			result, err := throttle.Store.Take("provider:"+provider, tier, throttle.Now())
+			if err != nil {
+				return nil, err
+			}
+			if result.Allowed {
+				break
+			}
+			timer := time.NewTimer(result.RetryAfter)
+			select {
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			case <-timer.C:
+			}
+		}
+	}
+
+	next := throttle.Next
+	if next == nil {
+		next = ProviderBaseTransport
+	}
+	return next.RoundTrip(req)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heroku/go-getting-started/client"
+	"github.com/onsi/gomega"
+)
+
+//TestBatchHandler checks arrays and NDJSON are answered with a result per applicant and bad records fail alone
+func TestBatchHandler(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	applicant := `{"firstname": "John", "lastname": "Smith", "dob": "1991/04/18", "credit-score": 500, "employment-status": "FULL_TIME", "salary": 30000}`
+	batch := &Batch{Config: BatchConfig{Concurrency: 2, MaxItems: 3, MaxBodyBytes: 1 << 20}, Decoding: DecodeConfig{DisallowUnknownFields: true}}
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message  string
+		Body     string
+		Code     int
+		Statuses []int
+		Error    string
+	}{
+		{Message: "should recommend every applicant of an array", Body: "[" + applicant + "," + applicant + "]", Code: 200, Statuses: []int{200, 200}},
+		{Message: "should recommend every line of NDJSON", Body: applicant + "\n\n" + applicant + "\n", Code: 200, Statuses: []int{200, 200}},
+		{Message: "should fail only the malformed record", Body: applicant + "\n{\"firstname\": \n" + applicant, Code: 200, Statuses: []int{200, 400, 200}},
+		{Message: "should fail only the record of the wrong type", Body: "[" + applicant + `, "John Smith"]`, Code: 200, Statuses: []int{200, 400}},
+		{Message: "should fail only the record with a misspelt field", Body: "[" + applicant + `, {"creditScore": 500}]`, Code: 200, Statuses: []int{200, 400}},
+		{Message: "should fail as the array is broken", Body: "[" + applicant, Code: 400, Error: "please enter the batch as a JSON array or one JSON object per line"},
+		{Message: "should fail as the batch is empty", Body: "[]", Code: 400, Error: "please enter at least one applicant"},
+		{Message: "should fail as the batch is too large", Body: strings.Repeat(applicant+"\n", 4), Code: 413, Error: "a batch may hold at most 3 applicants, got 4"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/creditcard/batch", strings.NewReader(test.Body))
+			rr := httptest.NewRecorder()
+			batch.Handler(rr, req)
+
+			g.Expect(rr.Code).To(gomega.Equal(test.Code))
+			if test.Code != 200 {
+				g.Expect(rr.Body.String()).To(gomega.Equal(test.Error))
+				return
+			}
+			g.Expect(rr.Header().Get("Content-Type")).To(gomega.Equal(NDJSONContentType))
+
+			//results come in the order they finish so they are sorted by index
+			var results []client.BatchResult
+			scanner := bufio.NewScanner(rr.Body)
+			for scanner.Scan() {
+				var result client.BatchResult
+				g.Expect(json.Unmarshal(scanner.Bytes(), &result)).To(gomega.Succeed())
+				results = append(results, result)
+			}
+			sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+			g.Expect(results).To(gomega.HaveLen(len(test.Statuses)))
+			for i, result := range results {
+				g.Expect(result.Index).To(gomega.Equal(i))
+				g.Expect(result.Status).To(gomega.Equal(test.Statuses[i]))
+				if result.Status == 200 {
+					g.Expect(result.Cards).To(gomega.HaveLen(3))
+					g.Expect(result.Problem).To(gomega.BeNil())
+				} else {
+					g.Expect(result.Problem.Type).To(gomega.Equal(client.ProblemInvalidRequest))
+				}
+			}
+		})
+	}
+
+	//a failing provider fails each applicant's result but not the batch
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+	ScoredCardsEndpoint = failing.URL
+	rr := httptest.NewRecorder()
+	batch.Handler(rr, httptest.NewRequest(http.MethodPost, "/v1/creditcard/batch", strings.NewReader(applicant)))
+	g.Expect(rr.Code).To(gomega.Equal(200))
+	var result client.BatchResult
+	g.Expect(json.Unmarshal(rr.Body.Bytes(), &result)).To(gomega.Succeed())
+	g.Expect(result.Status).To(gomega.Equal(400))
+	g.Expect(result.Problem.Provider).To(gomega.Equal("ScoredCards"))
+}
+
+//TestProviderThrottle checks requests wait for the provider's bucket and give up when their context is done
+func TestProviderThrottle(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	//one request every 100ms after the first
+	throttle := NewProviderThrottle(map[string]RateLimitTier{"CSCards": {RequestsPerMinute: 600, Burst: 1}}, nil)
+	httpClient := &http.Client{Transport: throttle}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := httpClient.Get(CSCardsEndpoint)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		resp.Body.Close()
+	}
+	g.Expect(time.Since(start)).To(gomega.BeNumerically(">=", 190*time.Millisecond))
+
+	//providers without a limit are not throttled
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := httpClient.Get(ScoredCardsEndpoint)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		resp.Body.Close()
+	}
+	g.Expect(time.Since(start)).To(gomega.BeNumerically("<", 90*time.Millisecond))
+
+	//a request that cannot wait any longer is not sent
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, CSCardsEndpoint, nil)
+	_, err := httpClient.Do(req.WithContext(ctx))
+	g.Expect(err).To(gomega.HaveOccurred())
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+//ProviderTransport is the transport provider requests go through, nil uses each provider's own tuned transport
+var ProviderTransport http.RoundTripper
+
+//DefaultScrubFields are the provider request fields that carry the applicant's personal data
+var DefaultScrubFields = []string{"fullName", "dateOfBirth", "creditScore", "first-name", "last-name", "date-of-birth", "score", "salary"}
+
+//Cassette is a file of recorded provider interactions
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+//Interaction is one recorded provider request and the response it got
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+//RecordedRequest is the part of a provider request replays are matched on
+type RecordedRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body"`
+}
+
+//RecordedResponse is the provider response served back on replay
+type RecordedResponse struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+//CassetteTransport records provider interactions to a cassette file or replays them from one
+type CassetteTransport struct {
+	//Path is the cassette file
+	Path string
+	//Record sends requests to Real and saves them, otherwise requests are served from the cassette
+	Record bool
+	//Real is the transport used when recording, nil uses ProviderBaseTransport
+	Real http.RoundTripper
+	//ScrubFields are the JSON body fields replaced by a placeholder before anything is saved
+	ScrubFields []string
+	//ScrubKey keys the hash of each scrubbed value kept in its placeholder, a cassette only replays with the key
+	//it was recorded with
+	ScrubKey []byte
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+//NewCassetteTransport creates a transport replaying from, or recording to, the cassette at path, scrubbed values
+//are hashed with the key
+func NewCassetteTransport(path string, record bool, key []byte) (*CassetteTransport, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("cassette %s needs a key to hash the scrubbed values with", path)
+	}
+	transport := &CassetteTransport{Path: path, Record: record, ScrubFields: DefaultScrubFields, ScrubKey: key}
+	if record {
+		return transport, nil
+	}
+
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cassette %s", path)
+	}
+	err = json.Unmarshal(body, &transport.cassette)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse cassette %s: %v", path, err)
+	}
+	return transport, nil
+}
+
+//RoundTrip records or replays a provider request
+func (transport *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	scrubbedBody, secrets := transport.scrubBody(reqBody)
+	recorded := RecordedRequest{Method: req.Method, Path: req.URL.Path, Body: scrubbedBody}
+
+	if transport.Record {
+		return transport.record(req, recorded, secrets)
+	}
+	return transport.replay(req, recorded)
+}
+
+func (transport *CassetteTransport) record(req *http.Request, recorded RecordedRequest, secrets map[string]string) (*http.Response, error) {
+	real := transport.Real
+	if real == nil {
+		real = ProviderBaseTransport
+	}
+	resp, err := real.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	//providers sometimes echo the applicant back, so their values are scrubbed from the response too
+	saved := transport.scrubResponse(respBody, secrets)
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	transport.cassette.Interactions = append(transport.cassette.Interactions, Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     http.Header{"Content-Type": resp.Header["Content-Type"]},
+			Body:       saved,
+		},
+	})
+	body, err := json.MarshalIndent(transport.cassette, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(transport.Path, body, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write cassette %s", transport.Path)
+	}
+	return resp, nil
+}
+
+func (transport *CassetteTransport) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	for _, interaction := range transport.cassette.Interactions {
+		if !interaction.Request.matches(recorded) {
+			continue
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        interaction.Response.Header,
+			Body:          ioutil.NopCloser(strings.NewReader(interaction.Response.Body)),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette %s has no interaction matching %s %s", transport.Path, recorded.Method, recorded.Path)
+}
+
+//matches compares method, path and the decoded body fields, so formatting differences in the body do not matter
+func (recorded RecordedRequest) matches(other RecordedRequest) bool {
+	if recorded.Method != other.Method || recorded.Path != other.Path {
+		return false
+	}
+	if len(recorded.Body) == 0 || len(other.Body) == 0 {
+		return len(recorded.Body) == len(other.Body)
+	}
+	var want, got interface{}
+	if json.Unmarshal(recorded.Body, &want) != nil || json.Unmarshal(other.Body, &got) != nil {
+		return bytes.Equal(recorded.Body, other.Body)
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+//scrubBody replaces the non-empty scrub fields of a JSON body with a placeholder naming the field and holding a keyed
+//hash of the value, so requests match on replay by their values without anything of the applicant being saved.
+//It returns the raw string values it replaced with their placeholders
+func (transport *CassetteTransport) scrubBody(body []byte) (json.RawMessage, map[string]string) {
+	secrets := map[string]string{}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, secrets
+	}
+
+	var fields map[string]interface{}
+	err := json.Unmarshal(body, &fields)
+	if err != nil {
+		//keeps a body that is not a JSON object only as a placeholder, it may hold anything
+		return json.RawMessage(`"<body>"`), secrets
+	}
+
+	for _, name := range transport.ScrubFields {
+		value, ok := fields[name]
+		if !ok || value == "" || value == nil {
+			continue
+		}
+		placeholder := transport.scrubPlaceholder(name, value)
+		fields[name] = placeholder
+		//only strings are looked for in responses, numbers such as a score would match unrelated values
+		if raw, isString := value.(string); isString && len(raw) > 1 {
+			secrets[raw] = placeholder
+		}
+	}
+	scrubbed, _ := json.Marshal(fields)
+	return scrubbed, secrets
+}
+
+//scrubPlaceholder is what a scrubbed field's value is saved as, the field name and the HMAC-SHA256 of its JSON value.
+//Without the key the hash cannot be reversed by trying every likely score or date of birth
+func (transport *CassetteTransport) scrubPlaceholder(field string, value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	mac := hmac.New(sha256.New, transport.ScrubKey)
+	mac.Write([]byte(field + "="))
+	mac.Write(encoded)
+	return "<" + field + ":" + hex.EncodeToString(mac.Sum(nil)[:16]) + ">"
+}
+
+//scrubResponse replaces, field by field, the scrub fields of a JSON response and any string value holding one of the
+//applicant's values, leaving every other value as the provider sent it. A response that is not JSON is kept only
+//if none of the values appears in it
+func (transport *CassetteTransport) scrubResponse(body []byte, secrets map[string]string) string {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	//keeps numbers as they were written rather than as float64
+	decoder.UseNumber()
+	var decoded interface{}
+	if decoder.Decode(&decoded) != nil {
+		for value := range secrets {
+			if strings.Contains(string(body), value) {
+				return "<body>"
+			}
+		}
+		return string(body)
+	}
+
+	scrubber := &responseScrubber{transport: transport, fields: map[string]bool{}, secrets: secrets}
+	for _, field := range transport.ScrubFields {
+		scrubber.fields[field] = true
+	}
+	decoded = scrubber.scrub(decoded)
+	if !scrubber.changed {
+		return string(body)
+	}
+	saved, _ := json.Marshal(decoded)
+	return string(saved)
+}
+
+//responseScrubber walks a decoded response replacing the applicant's values
+type responseScrubber struct {
+	transport *CassetteTransport
+	fields    map[string]bool
+	secrets   map[string]string
+	changed   bool
+}
+
+func (scrubber *responseScrubber) scrub(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, field := range v {
+			if scrubber.fields[name] && field != "" && field != nil {
+				v[name] = scrubber.transport.scrubPlaceholder(name, field)
+				scrubber.changed = true
+				continue
+			}
+			v[name] = scrubber.scrub(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = scrubber.scrub(v[i])
+		}
+	case string:
+		//a string holding the applicant's value, such as a greeting with their name, is replaced whole
+		for secret, placeholder := range scrubber.secrets {
+			if strings.Contains(v, secret) {
+				scrubber.changed = true
+				return placeholder
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+//TestCassetteRecordAndReplay records a provider that echoes the applicant back, checks the cassette
+//holds none of their data and that replay only answers requests matching method, path and body
+func TestCassetteRecordAndReplay(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"echo": ` + string(body) + `}`))
+	}))
+	defer provider.Close()
+
+	dir, err := ioutil.TempDir("", "cassette")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "provider.json")
+
+	post := func(transport http.RoundTripper, urlPath, body string) (*http.Response, error) {
+		req, _ := http.NewRequest("POST", provider.URL+urlPath, strings.NewReader(body))
+		return (&http.Client{Transport: transport}).Do(req)
+	}
+	applicant := `{"fullName": "Johnathan Smithers", "dateOfBirth": "1991/04/18", "creditScore": 512, "employment-status": "FULL_TIME"}`
+
+	//records one interaction
+	recorder, err := NewCassetteTransport(path, true, []byte("key"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	resp, err := post(recorder, "/CS/v1/cards", applicant)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	live, _ := ioutil.ReadAll(resp.Body)
+	g.Expect(string(live)).To(gomega.ContainSubstring("Johnathan Smithers"))
+
+	saved, err := ioutil.ReadFile(path)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	expectNoPII(g, string(saved))
+	g.Expect(string(saved)).To(gomega.ContainSubstring(`"creditScore": "\u003ccreditScore:`))
+
+	//replays it without the provider, whatever the body formatting
+	provider.Close()
+	player, err := NewCassetteTransport(path, false, []byte("key"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	resp, err = post(player, "/CS/v1/cards", `{"creditScore":512,"dateOfBirth":"1991/04/18","employment-status":"FULL_TIME","fullName":"Johnathan Smithers"}`)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(resp.StatusCode).To(gomega.Equal(http.StatusOK))
+	replayed, _ := ioutil.ReadAll(resp.Body)
+	g.Expect(string(replayed)).To(gomega.ContainSubstring(`"echo"`))
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Path    string
+		Body    string
+	}{
+		{Message: "should fail as the path differs", Path: "/CS/v2/creditcards", Body: applicant},
+		{Message: "should fail as a field that is not scrubbed differs", Path: "/CS/v1/cards", Body: `{"fullName": "Johnathan Smithers", "dateOfBirth": "1991/04/18", "creditScore": 512, "employment-status": "PART_TIME"}`},
+		{Message: "should fail as a scrubbed field is missing", Path: "/CS/v1/cards", Body: `{"fullName": "Johnathan Smithers", "creditScore": 512, "employment-status": "FULL_TIME"}`},
+		{Message: "should fail as a scrubbed value differs", Path: "/CS/v1/cards", Body: `{"fullName": "Johnathan Smithers", "dateOfBirth": "1991/04/18", "creditScore": 700, "employment-status": "FULL_TIME"}`},
+		{Message: "should fail as a scrubbed value has another type", Path: "/CS/v1/cards", Body: `{"fullName": "Johnathan Smithers", "dateOfBirth": "1991/04/18", "creditScore": "512", "employment-status": "FULL_TIME"}`},
+		{Message: "should fail as a scrubbed field is empty", Path: "/CS/v1/cards", Body: `{"fullName": "Johnathan Smithers", "dateOfBirth": "", "creditScore": 512, "employment-status": "FULL_TIME"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			_, err := post(player, test.Path, test.Body)
+			g.Expect(err).To(gomega.HaveOccurred())
+			g.Expect(err.Error()).To(gomega.ContainSubstring("has no interaction matching POST " + test.Path))
+		})
+	}
+}
+
+//TestScrubResponse checks only the applicant's values are replaced in a recorded response, by decoded field
+func TestScrubResponse(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	transport := &CassetteTransport{ScrubFields: DefaultScrubFields, ScrubKey: []byte("key")}
+	name := transport.scrubPlaceholder("fullName", "Johnathan Smithers")
+	secrets := map[string]string{"Johnathan Smithers": name}
+	//placeholders are escaped in the saved JSON
+	escaped := func(placeholder string) string {
+		return strings.NewReplacer("<", `\u003c`, ">", `\u003e`).Replace(placeholder)
+	}
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Body    string
+		Saved   string
+	}{
+		{Message: "should keep numbers that happen to equal the score", Body: `{"apr": 512, "cardName": "Card 512"}`, Saved: `{"apr": 512, "cardName": "Card 512"}`},
+		{Message: "should replace the scrub fields wherever they are", Body: `{"applicant": {"score": 512, "salary": 30000}, "apr": 19.20}`,
+			Saved: `{"applicant":{"salary":"` + escaped(transport.scrubPlaceholder("salary", 30000)) + `","score":"` + escaped(transport.scrubPlaceholder("score", 512)) + `"},"apr":19.20}`},
+		{Message: "should replace a string holding the applicant's name", Body: `["Welcome Johnathan Smithers", "SuperSaver"]`,
+			Saved: `["` + escaped(name) + `","SuperSaver"]`},
+		{Message: "should replace a body that is not JSON holding the name", Body: `hello Johnathan Smithers`, Saved: `<body>`},
+		{Message: "should keep a body that is not JSON without the name", Body: `Invalid request body`, Saved: `Invalid request body`},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			g.Expect(transport.scrubResponse([]byte(test.Body), secrets)).To(gomega.Equal(test.Saved))
+		})
+	}
+}
+
+//TestScrubPlaceholder checks placeholders tell values apart without holding them, and depend on the key
+func TestScrubPlaceholder(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	transport := &CassetteTransport{ScrubKey: []byte("key")}
+	other := &CassetteTransport{ScrubKey: []byte("other key")}
+	placeholder := transport.scrubPlaceholder("creditScore", 300)
+	g.Expect(placeholder).To(gomega.MatchRegexp(`^<creditScore:[0-9a-f]{32}>$`))
+	g.Expect(transport.scrubPlaceholder("creditScore", 300)).To(gomega.Equal(placeholder))
+	g.Expect(transport.scrubPlaceholder("creditScore", 700)).NotTo(gomega.Equal(placeholder))
+	g.Expect(transport.scrubPlaceholder("creditScore", "300")).NotTo(gomega.Equal(placeholder))
+	g.Expect(transport.scrubPlaceholder("score", 300)).NotTo(gomega.Equal(placeholder))
+	g.Expect(other.scrubPlaceholder("creditScore", 300)).NotTo(gomega.Equal(placeholder))
+
+	//a cassette cannot be made without a key
+	_, err := NewCassetteTransport("cassette.json", true, nil)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//usage lists the subcommands, serve is run when there is none so the Procfile keeps working
+const usage = `usage: go-getting-started <command> [flags]
+
+commands:
+  serve             serve the API and the form on $PORT (the default)
+  recommend         print the ranked cards for an applicant
+  check-providers   check every provider answers and its cards can be scored
+  config validate   check a config file
+  replay            re-score audited requests with the current formulas
+
+run a command with -h for its flags
+`
+
+//checkApplicant is sent by check-providers, it is made up so no customer's data leaves in a health check
+var checkApplicant = UserInfo{
+	FirstName:   "Health",
+	LastName:    "Check",
+	DOB:         "1990/01/01",
+	CreditScore: 500,
+	EmpStatus:   "FULL_TIME",
+	Salary:      30000,
+}
+
+//Run dispatches to a subcommand and returns the exit code
+func Run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return RunServe(nil, stderr)
+	}
+	switch args[0] {
+	case "serve":
+		return RunServe(args[1:], stderr)
+	case "recommend":
+		return RunRecommend(args[1:], stdout, stderr)
+	case "check-providers":
+		return RunCheckProviders(args[1:], stdout, stderr)
+	case "config":
+		if len(args) > 1 && args[1] == "validate" {
+			return RunConfigValidate(args[2:], stdout, stderr)
+		}
+	case "replay":
+		return RunReplay(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+	fmt.Fprint(stderr, usage)
+	return 2
+}
+
+//loadProviders loads the config file and sets up the adapters and any fault injection it asks for
+func loadProviders(configFile string) (*Config, error) {
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	ConfiguredProviders = NewAdapterProviders(cfg.Providers)
+	ConfigureProviderHTTP(cfg.ProviderHTTP)
+	if cfg.Faults.Enabled {
+		log.Printf("injecting provider faults in %s", cfg.Environment)
+		ProviderTransport = NewFaultTransport(cfg.Faults, nil)
+	}
+	//every provider call goes through the throttle, so no batch or burst of requests goes over a partner's limit
+	ProviderTransport = NewProviderThrottle(cfg.Batch.ProviderLimits, ProviderTransport)
+	return cfg, nil
+}
+
+//RunServe serves the API and the form until the server fails
+func RunServe(args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	port := flags.String("port", os.Getenv("PORT"), "port to listen on")
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "config file")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *port == "" {
+		fmt.Fprintln(stderr, "$PORT must be set")
+		return 2
+	}
+
+	cfg, err := loadProviders(*configFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	DefaultAuditor, err = NewAuditor(cfg.Audit)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	r, stop, err := NewRouter(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer stop()
+	err = http.ListenAndServe(":"+*port, r)
+	if err != nil {
+		fmt.Fprintf(stderr, "error occurred: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+//RunRecommend prints the ranked cards for an applicant read from a JSON file, stdin or flags, flags win over the file,
+//the request is not audited as it is a reproduction rather than a decision
+func RunRecommend(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("recommend", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "config file defining the provider adapters")
+	file := flags.String("file", "", "UserInfo JSON file, - for stdin")
+	format := flags.String("format", "table", "table or json")
+	var userInfo UserInfo
+	flags.StringVar(&userInfo.FirstName, "firstname", "", "first name")
+	flags.StringVar(&userInfo.LastName, "lastname", "", "last name")
+	flags.StringVar(&userInfo.DOB, "dob", "", "date of birth as YYYY/MM/DD")
+	flags.IntVar(&userInfo.CreditScore, "credit-score", 0, "credit score")
+	flags.StringVar(&userInfo.EmpStatus, "employment-status", "", "employment status, such as FULL_TIME")
+	flags.IntVar(&userInfo.Salary, "salary", 0, "yearly salary")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(stderr, "unknown format %s\n", *format)
+		return 2
+	}
+
+	if *file != "" {
+		var body []byte
+		if *file == "-" {
+			body, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			body, err = ioutil.ReadFile(*file)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to read %s\n", *file)
+			return 1
+		}
+		var fromFile UserInfo
+		err = json.Unmarshal(body, &fromFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s is not a UserInfo JSON object\n", *file)
+			return 1
+		}
+		//parses the flags again over the file's values so the ones given on the command line win
+		fromFlags := userInfo
+		userInfo = fromFile
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "firstname":
+				userInfo.FirstName = fromFlags.FirstName
+			case "lastname":
+				userInfo.LastName = fromFlags.LastName
+			case "dob":
+				userInfo.DOB = fromFlags.DOB
+			case "credit-score":
+				userInfo.CreditScore = fromFlags.CreditScore
+			case "employment-status":
+				userInfo.EmpStatus = fromFlags.EmpStatus
+			case "salary":
+				userInfo.Salary = fromFlags.Salary
+			}
+		})
+	}
+
+	_, err = loadProviders(*configFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	cards, err := Recommend(context.Background(), userInfo, "cli")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if *format == "json" {
+		JSONEncoder{}.Encode(stdout, cards)
+		return 0
+	}
+	PrintCardTable(stdout, cards)
+	return 0
+}
+
+//PrintCardTable writes the ranked cards as an aligned table
+func PrintCardTable(w io.Writer, cards []CreditCard) {
+	rows := []string{"RANK\tPROVIDER\tCARD\tAPR\tSCORE\tFEATURES"}
+	for i, card := range cards {
+		rows = append(rows, fmt.Sprintf("%d\t%s\t%s\t%.1f\t%.3f\t%s", i+1, card.Provider, card.Name, card.Apr, card.CardScore, strings.Join(card.Features, "; ")))
+	}
+	printTable(w, rows)
+}
+
+//printTable writes tab separated rows as aligned columns, without the padding tabwriter leaves after an empty last column
+func printTable(w io.Writer, rows []string) {
+	var buf bytes.Buffer
+	table := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(table, row)
+	}
+	table.Flush()
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line != "" {
+			fmt.Fprintln(w, strings.TrimRight(line, " \n"))
+		}
+	}
+}
+
+//ProviderCheck is the health of one provider, Decoded counts the cards in its answer and Scored the ones that could be ranked
+type ProviderCheck struct {
+	Provider string
+	Latency  time.Duration
+	Decoded  int
+	Scored   int
+	Err      error
+}
+
+//CheckProviders sends the made up applicant to every provider, each check gives up after the timeout
+func CheckProviders(timeout time.Duration) []ProviderCheck {
+	applicant := checkApplicant
+	//stops the calls still running once the checks have given up on them
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	checks := []struct {
+		name string
+		run  func() (int, int, error)
+	}{
+		{name: "CSCards", run: func() (int, int, error) {
+			responses, err := applicant.FetchCSCards(ctx)
+			cards, _ := ScoreCSCards(responses)
+			return len(responses), len(cards), err
+		}},
+		{name: "ScoredCards", run: func() (int, int, error) {
+			responses, err := applicant.FetchScoredCards(ctx)
+			cards, _ := ScoreScoredCards(responses)
+			return len(responses), len(cards), err
+		}},
+	}
+	for _, provider := range ConfiguredProviders {
+		provider := provider
+		checks = append(checks, struct {
+			name string
+			run  func() (int, int, error)
+		}{name: provider.Config.Name, run: func() (int, int, error) {
+			responses, err := provider.Fetch(ctx, &applicant)
+			cards, _ := provider.Score(responses)
+			return len(responses), len(cards), err
+		}})
+	}
+
+	results := make([]ProviderCheck, len(checks))
+	done := make(chan int, len(checks))
+	for i, check := range checks {
+		results[i].Provider = check.name
+		go func(i int, run func() (int, int, error)) {
+			start := time.Now()
+			decoded, scored, err := run()
+			results[i].Latency, results[i].Decoded, results[i].Scored, results[i].Err = time.Since(start), decoded, scored, err
+			done <- i
+		}(i, check.run)
+	}
+
+	finished := make([]bool, len(checks))
+	deadline := time.After(timeout)
+	for remaining := len(checks); remaining > 0; remaining-- {
+		select {
+		case i := <-done:
+			finished[i] = true
+		case <-deadline:
+			//copies out what has finished, the stragglers keep writing to their own slots
+			report := make([]ProviderCheck, len(checks))
+			for i := range checks {
+				if finished[i] {
+					report[i] = results[i]
+					continue
+				}
+				report[i] = ProviderCheck{Provider: checks[i].name, Latency: timeout, Err: fmt.Errorf("no answer within %s", timeout)}
+			}
+			return report
+		}
+	}
+	return results
+}
+
+//RunCheckProviders prints the health of every provider and exits 1 if any is unhealthy
+func RunCheckProviders(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check-providers", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "config file defining the provider adapters")
+	timeout := flags.Duration("timeout", 10*time.Second, "how long to wait for each provider")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	_, err = loadProviders(*configFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	code := 0
+	rows := []string{"PROVIDER\tSTATUS\tLATENCY\tCARDS\tDETAIL"}
+	for _, check := range CheckProviders(*timeout) {
+		latency := fmt.Sprintf("%dms", check.Latency/time.Millisecond)
+		if check.Err != nil {
+			code = 1
+			rows = append(rows, fmt.Sprintf("%s\tFAIL\t%s\t-\t%s", check.Provider, latency, providerCheckDetail(check.Err)))
+			continue
+		}
+		detail := ""
+		if check.Scored < check.Decoded {
+			detail = fmt.Sprintf("%d cards could not be scored", check.Decoded-check.Scored)
+		}
+		rows = append(rows, fmt.Sprintf("%s\tok\t%s\t%d\t%s", check.Provider, latency, check.Scored, detail))
+	}
+	printTable(stdout, rows)
+	return code
+}
+
+//providerCheckDetail says why a provider failed, with the start of its answer when it gave one
+func providerCheckDetail(err error) string {
+	providerErr, ok := err.(*ProviderError)
+	if !ok {
+		return err.Error()
+	}
+	body := strings.Join(strings.Fields(providerErr.Body), " ")
+	if len(body) > 80 {
+		body = body[:80] + "..."
+	}
+	return fmt.Sprintf("%s (status %d): %s", providerErr.Message, providerErr.StatusCode, body)
+}
+
+//RunConfigValidate checks a config file, including the key files it points at, without starting anything
+func RunConfigValidate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: config validate [file], $CONFIG_FILE by default\n")
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	path := os.Getenv("CONFIG_FILE")
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+	if path == "" {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := LoadConfig(path)
+	if err == nil {
+		_, err = NewKeyStore(cfg.Auth)
+	}
+	if err == nil {
+		_, err = NewJWTVerifier(cfg.JWT)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s: ok, environment %s, %d provider adapters\n", path, cfg.Environment, len(cfg.Providers))
+	return 0
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+//TestRun checks the subcommands are dispatched and unknown ones print the usage
+func TestRun(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Args    []string
+		Code    int
+		Stdout  string
+		Stderr  string
+	}{
+		{Message: "should print the usage for help", Args: []string{"help"}, Code: 0, Stdout: usage},
+		{Message: "should fail as the command is unknown", Args: []string{"frobnicate"}, Code: 2, Stderr: usage},
+		{Message: "should fail as config needs a subcommand", Args: []string{"config"}, Code: 2, Stderr: usage},
+		{Message: "should fail to serve without a port", Args: []string{"serve", "-port", ""}, Code: 2, Stderr: "$PORT must be set\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			g.Expect(Run(test.Args, &stdout, &stderr)).To(gomega.Equal(test.Code))
+			g.Expect(stdout.String()).To(gomega.Equal(test.Stdout))
+			g.Expect(stderr.String()).To(gomega.Equal(test.Stderr))
+		})
+	}
+}
+
+//TestRunRecommend checks an applicant from a file and flags is ranked and printed as a table or JSON
+func TestRunRecommend(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	dir, err := ioutil.TempDir("", "recommend")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "applicant.json")
+	g.Expect(ioutil.WriteFile(path, []byte(`{"firstname": "John", "lastname": "Smith", "dob": "1991/04/18", "credit-score": 500}`), 0600)).To(gomega.Succeed())
+
+	var stdout, stderr bytes.Buffer
+	g.Expect(RunRecommend([]string{"-file", path, "-salary", "30000"}, &stdout, &stderr)).To(gomega.Equal(0))
+	g.Expect(stdout.String()).To(gomega.Equal(`RANK  PROVIDER     CARD                APR   SCORE  FEATURES
+1     ScoredCards  ScoredCard Builder  19.4  0.212  Supports ApplePay; Interest free purchases for 1 month
+2     CSCards      SuperSaver Card     21.4  0.137
+3     CSCards      SuperSpender Card   19.2  0.135  Interest free purchases for 6 months
+`))
+
+	stdout.Reset()
+	g.Expect(RunRecommend([]string{"-format", "json", "-firstname", "John", "-lastname", "Smith", "-dob", "1991/04/18"}, &stdout, &stderr)).To(gomega.Equal(0))
+	g.Expect(stdout.String()).To(gomega.HavePrefix(`[{"provider":"ScoredCards","name":"ScoredCard Builder"`))
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Args    []string
+		Code    int
+		Stderr  string
+	}{
+		{Message: "should fail as the format is unknown", Args: []string{"-format", "yaml"}, Code: 2, Stderr: "unknown format yaml\n"},
+		{Message: "should fail as the file is missing", Args: []string{"-file", filepath.Join(dir, "missing.json")}, Code: 1, Stderr: "unable to read " + filepath.Join(dir, "missing.json") + "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			stderr.Reset()
+			g.Expect(RunRecommend(test.Args, &stdout, &stderr)).To(gomega.Equal(test.Code))
+			g.Expect(stderr.String()).To(gomega.Equal(test.Stderr))
+		})
+	}
+}
+
+//TestRunCheckProviders checks healthy, failing and silent providers are reported
+func TestRunCheckProviders(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//one CSCards card cannot be scored as it has no APR
+	stop := startFakeProviders(`[{"cardName": "SuperSaver Card", "apr": 21.4, "eligibility": 6.3}, {"cardName": "Broken Card", "eligibility": 5}]`, scoredCardsFixture)
+	defer stop()
+
+	checks := CheckProviders(time.Second)
+	g.Expect(checks).To(gomega.HaveLen(2))
+	g.Expect(checks[0].Err).NotTo(gomega.HaveOccurred())
+	g.Expect(checks[0].Decoded).To(gomega.Equal(2))
+	g.Expect(checks[0].Scored).To(gomega.Equal(1))
+	g.Expect(checks[1].Scored).To(gomega.Equal(1))
+
+	var stdout, stderr bytes.Buffer
+	g.Expect(RunCheckProviders(nil, &stdout, &stderr)).To(gomega.Equal(0))
+	g.Expect(stdout.String()).To(gomega.MatchRegexp(`CSCards +ok +\d+ms +1 +1 cards could not be scored\n`))
+	g.Expect(stdout.String()).To(gomega.MatchRegexp(`ScoredCards +ok +\d+ms +1\n`))
+
+	//a provider answering with an error fails the check
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"message": "down for maintenance"}`))
+	}))
+	defer failing.Close()
+	//a provider that never answers is given up on
+	silent := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-silent
+	}))
+	defer hanging.Close()
+	defer close(silent)
+	ScoredCardsEndpoint, CSCardsEndpoint = failing.URL, hanging.URL
+
+	stdout.Reset()
+	g.Expect(RunCheckProviders([]string{"-timeout", "100ms"}, &stdout, &stderr)).To(gomega.Equal(1))
+	g.Expect(stdout.String()).To(gomega.ContainSubstring("CSCards      FAIL    100ms"))
+	g.Expect(stdout.String()).To(gomega.ContainSubstring("no answer within 100ms"))
+	g.Expect(stdout.String()).To(gomega.ContainSubstring(`(status 503): {"message": "down for maintenance"}`))
+}
+
+//TestRunConfigValidate checks valid and invalid config files
+func TestRunConfigValidate(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "config")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	write := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		g.Expect(ioutil.WriteFile(path, []byte(body), 0600)).To(gomega.Succeed())
+		return path
+	}
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Path    string
+		Code    int
+		Stdout  string
+		Stderr  string
+	}{
+		{Message: "should pass as the config is valid", Path: write("valid.json", `{"environment": "staging"}`), Code: 0,
+			Stdout: filepath.Join(dir, "valid.json") + ": ok, environment staging, 0 provider adapters\n"},
+		{Message: "should fail as the config is not JSON", Path: write("broken.json", `{`), Code: 1,
+			Stderr: filepath.Join(dir, "broken.json") + ": unable to parse config file " + filepath.Join(dir, "broken.json") + ": unexpected end of JSON input\n"},
+		{Message: "should fail as the rsa key file is missing", Path: write("jwt.json", `{"jwt": {"rsa-public-key-files": {"k1": "/nonexistent.pem"}}}`), Code: 1,
+			Stderr: filepath.Join(dir, "jwt.json") + ": unable to read rsa public key file /nonexistent.pem\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			g.Expect(Run([]string{"config", "validate", test.Path}, &stdout, &stderr)).To(gomega.Equal(test.Code))
+			g.Expect(stdout.String()).To(gomega.Equal(test.Stdout))
+			g.Expect(stderr.String()).To(gomega.Equal(test.Stderr))
+		})
+	}
+}
@@ -0,0 +1,214 @@
+//Package client calls the credit card recommendation API, it shares its request and response models with the server
+//and turns the server's problem+json errors into typed Go errors
+package client
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Client calls the API at BaseURL, set APIKey, BearerToken or TokenSource when the server has authentication enabled
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	APIKey     string
+	//BearerToken is sent as is, TokenSource is asked for a token before every attempt instead when it is set
+	BearerToken string
+	TokenSource func(ctx context.Context) (string, error)
+	//MaxRetries is how many times a failed call is retried, Backoff doubles from its value between attempts up to MaxBackoff
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+//New makes a Client with two retries and a 30 second timeout per attempt
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: 2,
+		Backoff:    200 * time.Millisecond,
+		MaxBackoff: 5 * time.Second,
+	}
+}
+
+//IdempotencyKeyHeader is the header that lets the server answer a retried POST with the response to the first attempt
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+//Recommend returns the cards recommended for the applicant, best first, retrying provider failures,
+//rate limiting and network errors until ctx is done. Every attempt carries the same Idempotency-Key, so an
+//attempt whose answer was lost is replayed by the server instead of asking the providers again
+func (c *Client) Recommend(ctx context.Context, userInfo UserInfo) ([]CreditCard, error) {
+	body, err := json.Marshal(userInfo)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []CreditCard
+	err = c.do(ctx, http.MethodPost, "/v1/creditcard", http.Header{IdempotencyKeyHeader: {key}}, body, &cards)
+	if err != nil {
+		return nil, err
+	}
+	return cards, nil
+}
+
+//newIdempotencyKey makes a random key for one logical call
+func newIdempotencyKey() (string, error) {
+	key := make([]byte, 16)
+	_, err := crand.Read(key)
+	if err != nil {
+		return "", fmt.Errorf("unable to make an idempotency key: %v", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+//do sends a request with the given headers and decodes the answer into out, retrying what may succeed on another attempt
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body []byte, out interface{}) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = c.attempt(ctx, method, path, header, body, out)
+		if err == nil || attempt >= c.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		wait := c.backoff(attempt)
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if c.MaxBackoff > 0 && wait > c.MaxBackoff {
+			wait = c.MaxBackoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+//attempt makes one call, it also returns how long a rate limited answer asked the client to wait
+func (c *Client) attempt(ctx context.Context, method, path string, header http.Header, body []byte, out interface{}) (time.Duration, error) {
+	req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, "+ProblemContentType)
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	token := c.BearerToken
+	if c.TokenSource != nil {
+		token, err = c.TokenSource(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("unable to get a bearer token: %v", err)
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, &networkError{err}
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, &networkError{err}
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		err = json.Unmarshal(respBody, out)
+		if err != nil {
+			return 0, fmt.Errorf("unable to decode the response: %v", err)
+		}
+		return 0, nil
+	}
+
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+	return retryAfter, typedError(decodeProblem(resp, respBody), retryAfter)
+}
+
+//decodeProblem reads a problem+json body, or makes a problem from the status and text of any other error body
+func decodeProblem(resp *http.Response, body []byte) *Problem {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), ProblemContentType) {
+		problem := &Problem{}
+		if json.Unmarshal(body, problem) == nil && problem.Status != 0 {
+			return problem
+		}
+	}
+	problemType := problemTypeForStatus(resp.StatusCode)
+	title := ProblemTitles[problemType]
+	if title == "" {
+		title = http.StatusText(resp.StatusCode)
+	}
+	return &Problem{Type: problemType, Title: title, Status: resp.StatusCode, Detail: strings.TrimSpace(string(body))}
+}
+
+//networkError is a failure to reach the server or read its answer
+type networkError struct {
+	err error
+}
+
+func (err *networkError) Error() string {
+	return err.err.Error()
+}
+
+//retryable reports whether another attempt may succeed
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *networkError, *ProviderUnavailableError, *RateLimitedError:
+		return true
+	case *Problem:
+		//a conflict is an earlier attempt with the same idempotency key that is still running
+		return e.Type == ProblemConflict || e.Status == http.StatusBadGateway || e.Status == http.StatusServiceUnavailable || e.Status == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+//backoff is the wait before the next attempt, doubling each time with jitter so clients do not retry in step
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.Backoff << uint(attempt)
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+//parseRetryAfter reads a Retry-After header given in seconds, the form the server sends
+func parseRetryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+//testApplicant is posted by these tests
+var testApplicant = UserInfo{FirstName: "John", LastName: "Smith", DOB: "1991/04/18", CreditScore: 500, EmpStatus: "FULL_TIME", Salary: 30000}
+
+//problemServer answers with the given problems in turn, then with one card
+func problemServer(problems ...*Problem) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(atomic.AddInt32(&calls, 1)) - 1
+		if call < len(problems) {
+			if problems[call].Type == ProblemRateLimited {
+				w.Header().Set("Retry-After", "1")
+			}
+			w.Header().Set("Content-Type", ProblemContentType)
+			w.WriteHeader(problems[call].Status)
+			json.NewEncoder(w).Encode(problems[call])
+			return
+		}
+		w.Write([]byte(`[{"provider": "CSCards", "name": "SuperSaver Card", "apr": 21.4, "card-score": 0.137}]`))
+	}))
+	return server, &calls
+}
+
+//newTestClient makes a client that backs off for a millisecond at most
+func newTestClient(url string) *Client {
+	c := New(url)
+	c.Backoff = time.Millisecond
+	c.MaxBackoff = 10 * time.Millisecond
+	return c
+}
+
+//TestRecommend checks the request the client sends and the cards it decodes
+func TestRecommend(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.Method).To(gomega.Equal(http.MethodPost))
+		g.Expect(r.URL.Path).To(gomega.Equal("/v1/creditcard"))
+		g.Expect(r.Header.Get("Accept")).To(gomega.Equal("application/json, application/problem+json"))
+		g.Expect(r.Header.Get("X-API-Key")).To(gomega.Equal("key"))
+		g.Expect(r.Header.Get("Authorization")).To(gomega.Equal("Bearer fresh-token"))
+		var userInfo UserInfo
+		g.Expect(json.NewDecoder(r.Body).Decode(&userInfo)).To(gomega.Succeed())
+		g.Expect(userInfo).To(gomega.Equal(testApplicant))
+		w.Write([]byte(`[{"provider": "CSCards", "name": "SuperSaver Card", "apply-url": "http://www.example.com/apply", "apr": 21.4, "card-score": 0.137}]`))
+	}))
+	defer server.Close()
+
+	c := newTestClient(server.URL + "/")
+	c.APIKey = "key"
+	c.BearerToken = "stale-token"
+	c.TokenSource = func(ctx context.Context) (string, error) { return "fresh-token", nil }
+	cards, err := c.Recommend(context.Background(), testApplicant)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(cards).To(gomega.Equal([]CreditCard{{Provider: "CSCards", Name: "SuperSaver Card", ApplyURL: "http://www.example.com/apply", Apr: 21.4, CardScore: 0.137}}))
+}
+
+//TestRecommendErrors checks each problem becomes its typed error and only transient ones are retried
+func TestRecommendErrors(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	providerDown := NewProblem(ProblemProviderUnavailable, 400, "unable to retrieve CSCards")
+	providerDown.Provider = "CSCards"
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message  string
+		Problems []*Problem
+		Calls    int32
+		Check    func(err error)
+	}{
+		{Message: "should succeed as a provider failure is retried", Problems: []*Problem{providerDown, providerDown}, Calls: 3,
+			Check: func(err error) { g.Expect(err).NotTo(gomega.HaveOccurred()) }},
+		{Message: "should fail with the provider once the retries are used up", Problems: []*Problem{providerDown, providerDown, providerDown}, Calls: 3,
+			Check: func(err error) {
+				g.Expect(err).To(gomega.BeAssignableToTypeOf(&ProviderUnavailableError{}))
+				g.Expect(err.(*ProviderUnavailableError).Provider).To(gomega.Equal("CSCards"))
+				g.Expect(err.Error()).To(gomega.Equal("400 Provider unavailable: unable to retrieve CSCards"))
+			}},
+		{Message: "should fail without retrying as the request is invalid", Problems: []*Problem{NewProblem(ProblemInvalidRequest, 400, "please enter user info")}, Calls: 1,
+			Check: func(err error) { g.Expect(err).To(gomega.BeAssignableToTypeOf(&InvalidRequestError{})) }},
+		{Message: "should fail without retrying as the body is too large", Problems: []*Problem{NewProblem(ProblemPayloadTooLarge, 413, "please enter a body of at most 65536 bytes")}, Calls: 1,
+			Check: func(err error) { g.Expect(err).To(gomega.BeAssignableToTypeOf(&PayloadTooLargeError{})) }},
+		{Message: "should fail without retrying as the body is not JSON", Problems: []*Problem{NewProblem(ProblemUnsupportedMediaType, 415, "please send the body as application/json, not text/plain")}, Calls: 1,
+			Check: func(err error) { g.Expect(err).To(gomega.BeAssignableToTypeOf(&UnsupportedMediaTypeError{})) }},
+		{Message: "should fail without retrying as the key is rejected", Problems: []*Problem{NewProblem(ProblemForbidden, 403, "api key is not allowed to call this route")}, Calls: 1,
+			Check: func(err error) { g.Expect(err).To(gomega.BeAssignableToTypeOf(&AuthError{})) }},
+		{Message: "should succeed as an attempt still running is retried", Problems: []*Problem{NewProblem(ProblemConflict, 409, "a request with this idempotency key is still running, please retry later")}, Calls: 2,
+			Check: func(err error) { g.Expect(err).NotTo(gomega.HaveOccurred()) }},
+		{Message: "should succeed as a rate limited call is retried", Problems: []*Problem{NewProblem(ProblemRateLimited, 429, "rate limit exceeded")}, Calls: 2,
+			Check: func(err error) { g.Expect(err).NotTo(gomega.HaveOccurred()) }},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			server, calls := problemServer(test.Problems...)
+			defer server.Close()
+
+			_, err := newTestClient(server.URL).Recommend(context.Background(), testApplicant)
+			test.Check(err)
+			g.Expect(atomic.LoadInt32(calls)).To(gomega.Equal(test.Calls))
+		})
+	}
+}
+
+//TestRecommendIdempotencyKey checks every attempt of a call sends the same key and each call sends its own
+func TestRecommendIdempotencyKey(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	var keys []string
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		//fails the first attempt of each call
+		if atomic.AddInt32(&calls, 1)%2 == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	c := newTestClient(server.URL)
+	for i := 0; i < 2; i++ {
+		_, err := c.Recommend(context.Background(), testApplicant)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+	g.Expect(keys).To(gomega.HaveLen(4))
+	g.Expect(keys[0]).To(gomega.HaveLen(32))
+	g.Expect(keys[1]).To(gomega.Equal(keys[0]))
+	g.Expect(keys[3]).To(gomega.Equal(keys[2]))
+	g.Expect(keys[2]).NotTo(gomega.Equal(keys[0]))
+}
+
+//TestRecommendRateLimited checks the Retry-After the server asked for is reported
+func TestRecommendRateLimited(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	server, _ := problemServer(NewProblem(ProblemRateLimited, 429, "rate limit exceeded"))
+	defer server.Close()
+	c := newTestClient(server.URL)
+	c.MaxRetries = 0
+
+	_, err := c.Recommend(context.Background(), testApplicant)
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&RateLimitedError{}))
+	g.Expect(err.(*RateLimitedError).RetryAfter).To(gomega.Equal(time.Second))
+}
+
+//TestRecommendPlainTextErrors checks errors from servers or proxies that do not send problems are still typed
+func TestRecommendPlainTextErrors(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("please provide an api key\n"))
+	}))
+	defer server.Close()
+
+	_, err := newTestClient(server.URL).Recommend(context.Background(), testApplicant)
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&AuthError{}))
+	g.Expect(err.(*AuthError).Detail).To(gomega.Equal("please provide an api key"))
+
+	//a proxy refusing a large body is typed from the status alone
+	tooLarge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}))
+	defer tooLarge.Close()
+	_, err = newTestClient(tooLarge.URL).Recommend(context.Background(), testApplicant)
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&PayloadTooLargeError{}))
+
+	//a gateway error is retried and then returned as a plain problem
+	var calls int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer gateway.Close()
+	_, err = newTestClient(gateway.URL).Recommend(context.Background(), testApplicant)
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&Problem{}))
+	g.Expect(err.(*Problem).Status).To(gomega.Equal(http.StatusBadGateway))
+	g.Expect(atomic.LoadInt32(&calls)).To(gomega.Equal(int32(3)))
+}
+
+//TestRecommendContext checks a cancelled context stops the call and its retries
+func TestRecommendContext(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := newTestClient(server.URL).Recommend(ctx, testApplicant)
+	g.Expect(err).To(gomega.Equal(context.DeadlineExceeded))
+	g.Expect(time.Since(start)).To(gomega.BeNumerically("<", time.Second))
+
+	//the wait between retries is cut short too
+	providerDown := NewProblem(ProblemProviderUnavailable, 400, "unable to retrieve CSCards")
+	retrying, _ := problemServer(providerDown, providerDown, providerDown)
+	defer retrying.Close()
+	c := newTestClient(retrying.URL)
+	c.Backoff, c.MaxBackoff = time.Hour, time.Hour
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Recommend(ctx, testApplicant)
+	g.Expect(err).To(gomega.Equal(context.DeadlineExceeded))
+}
@@ -0,0 +1,61 @@
+package client
+
+import "time"
+
+//UserInfo is the applicant posted to /v1/creditcard, the server's UserInfo is defined from it so the two cannot drift apart,
+//fields tagged pii are the applicant's personal data and are masked by the server before they reach its logs
+type UserInfo struct {
+	FirstName   string `json:"firstname" binding:"required" pii:"mask"`
+	LastName    string `json:"lastname" binding:"required" pii:"mask"`
+	DOB         string `json:"dob" binding:"required" pii:"mask"`
+	CreditScore int    `json:"credit-score" binding:"required" pii:"mask"`
+	EmpStatus   string `json:"employment-status" binding:"required"`
+	Salary      int    `json:"salary" binding:"required" pii:"mask"`
+}
+
+//CreditCard is one recommended card, /v1/creditcard answers with them ranked by CardScore
+type CreditCard struct {
+	Provider  string   `json:"provider"`
+	Name      string   `json:"name"`
+	ApplyURL  string   `json:"apply-url"`
+	Apr       float64  `json:"apr"`
+	Features  []string `json:"features"`
+	CardScore float64  `json:"card-score"`
+}
+
+//BatchResult is one line of the NDJSON stream /v1/creditcard/batch answers with, Index is the applicant's position in the batch,
+//Status is 200 with the ranked Cards or the status of the Problem that applicant ran into
+type BatchResult struct {
+	Index   int          `json:"index"`
+	Status  int          `json:"status"`
+	Cards   []CreditCard `json:"cards,omitempty"`
+	Problem *Problem     `json:"problem,omitempty"`
+}
+
+//Job statuses, a job is queued until a worker picks it up and ends up succeeded or failed
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+//Job is an asynchronous recommendation, POST /v1/jobs/creditcard answers with it queued and GET /v1/jobs/{id} with its latest state,
+//Cards are set once it has succeeded and Problem once it has failed, it is forgotten at ExpiresAt
+type Job struct {
+	ID          string       `json:"id"`
+	Status      string       `json:"status"`
+	CreatedAt   time.Time    `json:"created-at"`
+	CompletedAt *time.Time   `json:"completed-at,omitempty"`
+	ExpiresAt   *time.Time   `json:"expires-at,omitempty"`
+	Cards       []CreditCard `json:"cards,omitempty"`
+	Problem     *Problem     `json:"problem,omitempty"`
+}
+
+//ProviderEvent is the data of a provider event on the /v1/creditcard/stream Server-Sent Events stream,
+//Cards are what Provider offered and Ranked is every card offered so far, best first
+type ProviderEvent struct {
+	Provider string       `json:"provider"`
+	Cards    []CreditCard `json:"cards"`
+	Ranked   []CreditCard `json:"ranked"`
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+//ProblemContentType is the media type of RFC 7807 error bodies, the server only sends them to clients that accept it
+const ProblemContentType = "application/problem+json"
+
+//Problem types the server answers with
+const (
+	ProblemInvalidRequest       = "/problems/invalid-request"
+	ProblemProviderUnavailable  = "/problems/provider-unavailable"
+	ProblemNotAcceptable        = "/problems/not-acceptable"
+	ProblemUnauthorized         = "/problems/unauthorized"
+	ProblemForbidden            = "/problems/forbidden"
+	ProblemRateLimited          = "/problems/rate-limited"
+	ProblemInternal             = "/problems/internal"
+	ProblemNotFound             = "/problems/not-found"
+	ProblemUnavailable          = "/problems/unavailable"
+	ProblemTimeout              = "/problems/timeout"
+	ProblemConflict             = "/problems/conflict"
+	ProblemIdempotencyKeyReused = "/problems/idempotency-key-reused"
+	ProblemUnsupportedMediaType = "/problems/unsupported-media-type"
+	ProblemPayloadTooLarge      = "/problems/payload-too-large"
+)
+
+//ProblemTitles are the short summaries of each problem type
+var ProblemTitles = map[string]string{
+	ProblemInvalidRequest:       "Invalid request",
+	ProblemProviderUnavailable:  "Provider unavailable",
+	ProblemNotAcceptable:        "Not acceptable",
+	ProblemUnauthorized:         "Unauthorized",
+	ProblemForbidden:            "Forbidden",
+	ProblemRateLimited:          "Too many requests",
+	ProblemInternal:             "Internal error",
+	ProblemNotFound:             "Not found",
+	ProblemUnavailable:          "Service unavailable",
+	ProblemTimeout:              "Timed out",
+	ProblemConflict:             "Conflict",
+	ProblemIdempotencyKeyReused: "Idempotency key reused",
+	ProblemUnsupportedMediaType: "Unsupported media type",
+	ProblemPayloadTooLarge:      "Payload too large",
+}
+
+//Problem is an RFC 7807 error body, Provider names the provider that failed for provider-unavailable problems
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Provider string `json:"provider,omitempty"`
+}
+
+//NewProblem makes a problem of a known type
+func NewProblem(problemType string, status int, detail string) *Problem {
+	return &Problem{Type: problemType, Title: ProblemTitles[problemType], Status: status, Detail: detail}
+}
+
+func (problem *Problem) Error() string {
+	if problem.Detail == "" {
+		return fmt.Sprintf("%d %s", problem.Status, problem.Title)
+	}
+	return fmt.Sprintf("%d %s: %s", problem.Status, problem.Title, problem.Detail)
+}
+
+//InvalidRequestError is returned when the server cannot use the applicant, retrying will not help
+type InvalidRequestError struct{ *Problem }
+
+//ProviderUnavailableError is returned when a card provider failed, Provider says which, it is retried
+type ProviderUnavailableError struct{ *Problem }
+
+//PayloadTooLargeError is returned when the body or batch is larger than the server accepts, it has to be split to succeed
+type PayloadTooLargeError struct{ *Problem }
+
+//UnsupportedMediaTypeError is returned when the body was not sent as a media type the server reads
+type UnsupportedMediaTypeError struct{ *Problem }
+
+//AuthError is returned for a missing, invalid or insufficient API key or bearer token
+type AuthError struct{ *Problem }
+
+//RateLimitedError is returned when the client has used its quota, RetryAfter is how long the server asked it to wait
+type RateLimitedError struct {
+	*Problem
+	RetryAfter time.Duration
+}
+
+//typedError wraps a problem in the error type for its problem type, other problems are returned as they are
+func typedError(problem *Problem, retryAfter time.Duration) error {
+	switch problem.Type {
+	case ProblemInvalidRequest:
+		return &InvalidRequestError{problem}
+	case ProblemProviderUnavailable:
+		return &ProviderUnavailableError{problem}
+	case ProblemPayloadTooLarge:
+		return &PayloadTooLargeError{problem}
+	case ProblemUnsupportedMediaType:
+		return &UnsupportedMediaTypeError{problem}
+	case ProblemUnauthorized, ProblemForbidden:
+		return &AuthError{problem}
+	case ProblemRateLimited:
+		return &RateLimitedError{Problem: problem, RetryAfter: retryAfter}
+	}
+	return problem
+}
+
+//problemTypeForStatus guesses the problem type of a plain text error from a server or proxy that does not send problems
+func problemTypeForStatus(status int) string {
+	switch status {
+	case 400:
+		return ProblemInvalidRequest
+	case 401:
+		return ProblemUnauthorized
+	case 403:
+		return ProblemForbidden
+	case 404:
+		return ProblemNotFound
+	case 406:
+		return ProblemNotAcceptable
+	case 409:
+		return ProblemConflict
+	case 413:
+		return ProblemPayloadTooLarge
+	case 415:
+		return ProblemUnsupportedMediaType
+	case 422:
+		return ProblemIdempotencyKeyReused
+	case 429:
+		return ProblemRateLimited
+	}
+	return "about:blank"
+}
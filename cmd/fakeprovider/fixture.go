@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+//Fixture is the file the fake provider serves its cards, rules and scenarios from
+type Fixture struct {
+	CSCards     []FixtureCard     `json:"cscards"`
+	ScoredCards []FixtureCard     `json:"scoredcards"`
+	Rules       []EligibilityRule `json:"rules"`
+	Scenarios   []Scenario        `json:"scenarios"`
+}
+
+//FixtureCard is a card offered by one of the fake providers
+type FixtureCard struct {
+	Name        string   `json:"name"`
+	URL         string   `json:"url"`
+	Apr         float64  `json:"apr"`
+	Features    []string `json:"features"`
+	IntroOffers []string `json:"introductory-offers"`
+}
+
+//EligibilityRule gives the eligibility, out of 10, of applicants whose credit score is in
+//[MinScore, MaxScore], the first matching rule wins and a card is not offered if no rule matches
+//or the matching rule's eligibility is 0
+type EligibilityRule struct {
+	//Card limits the rule to one card, empty matches every card
+	Card     string `json:"card"`
+	MinScore int    `json:"min-score"`
+	//MaxScore of 0 means no upper bound
+	MaxScore    int     `json:"max-score"`
+	Eligibility float64 `json:"eligibility"`
+}
+
+//Scenario changes how a provider answers once it applies, the first applying scenario wins
+type Scenario struct {
+	Name string `json:"name"`
+	//Provider is cscards or scoredcards, empty matches both
+	Provider string `json:"provider"`
+	//AfterCalls is how many calls to the provider are answered normally before the scenario starts
+	AfterCalls int `json:"after-calls"`
+	//BelowScore limits the scenario to applicants with a lower credit score, 0 matches everyone
+	BelowScore int `json:"below-score"`
+	//Action is one of empty, status, delay or malformed
+	Action      string `json:"action"`
+	Status      int    `json:"status"`
+	DelayMillis int    `json:"delay-millis"`
+}
+
+//scenarioActions are the actions a scenario can take
+var scenarioActions = map[string]bool{
+	"empty":     true,
+	"status":    true,
+	"delay":     true,
+	"malformed": true,
+}
+
+//LoadFixture reads and validates a fixture file
+func LoadFixture(path string) (*Fixture, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read fixture %s", path)
+	}
+	fixture := &Fixture{}
+	err = json.Unmarshal(body, fixture)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse fixture %s", path)
+	}
+	err = fixture.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return fixture, nil
+}
+
+//LoadScenarios reads a scenario script, a JSON list of scenarios that replaces the fixture's own
+func (fixture *Fixture) LoadScenarios(path string) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read scenarios %s", path)
+	}
+	var scenarios []Scenario
+	err = json.Unmarshal(body, &scenarios)
+	if err != nil {
+		return fmt.Errorf("unable to parse scenarios %s", path)
+	}
+	fixture.Scenarios = scenarios
+	return fixture.Validate()
+}
+
+//Validate checks the rules and scenarios make sense
+func (fixture *Fixture) Validate() error {
+	for _, rule := range fixture.Rules {
+		if rule.Eligibility < 0 || rule.Eligibility > 10 {
+			return fmt.Errorf("eligibility must be between 0 and 10")
+		}
+		if rule.MaxScore != 0 && rule.MaxScore < rule.MinScore {
+			return fmt.Errorf("max-score must not be below min-score")
+		}
+	}
+	for _, scenario := range fixture.Scenarios {
+		if !scenarioActions[scenario.Action] {
+			return fmt.Errorf("unknown scenario action %q", scenario.Action)
+		}
+		if scenario.Provider != "" && scenario.Provider != "cscards" && scenario.Provider != "scoredcards" {
+			return fmt.Errorf("unknown scenario provider %q", scenario.Provider)
+		}
+	}
+	return nil
+}
+
+//Eligibility returns the eligibility out of 10 of an applicant for a card,
+//false if no rule matches and the card should not be offered
+func (fixture *Fixture) Eligibility(card string, creditScore int) (float64, bool) {
+	for _, rule := range fixture.Rules {
+		if rule.Card != "" && rule.Card != card {
+			continue
+		}
+		if creditScore < rule.MinScore || (rule.MaxScore != 0 && creditScore > rule.MaxScore) {
+			continue
+		}
+		return rule.Eligibility, rule.Eligibility > 0
+	}
+	return 0, false
+}
+
+//Scenario returns the scenario that applies to a call, calls counts the provider's calls before this one
+func (fixture *Fixture) Scenario(provider string, calls, creditScore int) *Scenario {
+	for i, scenario := range fixture.Scenarios {
+		if scenario.Provider != "" && scenario.Provider != provider {
+			continue
+		}
+		if calls < scenario.AfterCalls {
+			continue
+		}
+		if scenario.BelowScore != 0 && creditScore >= scenario.BelowScore {
+			continue
+		}
+		return &fixture.Scenarios[i]
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+//TestLoadFixture checks the shipped fixture and scenario scripts load
+func TestLoadFixture(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	fixture, err := LoadFixture("fixture.json")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(fixture.CSCards).To(gomega.HaveLen(2))
+	g.Expect(fixture.ScoredCards).To(gomega.HaveLen(1))
+
+	for _, path := range []string{"scenarios/degrade.json", "scenarios/thin-file.json"} {
+		g.Expect(fixture.LoadScenarios(path)).To(gomega.Succeed())
+	}
+	g.Expect(fixture.LoadScenarios("scenarios/missing.json")).To(gomega.MatchError("unable to read scenarios scenarios/missing.json"))
+}
+
+//TestFixtureEligibility checks the first matching rule gives the eligibility
+func TestFixtureEligibility(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	fixture, err := LoadFixture("fixture.json")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message     string
+		Card        string
+		CreditScore int
+		Eligibility float64
+		Offered     bool
+	}{
+		{Message: "should be very eligible with a high score", Card: "SuperSaver Card", CreditScore: 750, Eligibility: 9.0, Offered: true},
+		{Message: "should be fairly eligible with a middling score", Card: "SuperSaver Card", CreditScore: 500, Eligibility: 6.3, Offered: true},
+		{Message: "should not be offered the card limited to higher scores", Card: "SuperSpender Card", CreditScore: 500, Offered: false},
+		{Message: "should be offered the card limited to higher scores", Card: "SuperSpender Card", CreditScore: 650, Eligibility: 6.3, Offered: true},
+		{Message: "should not be offered anything below every rule", Card: "SuperSaver Card", CreditScore: 200, Offered: false},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			eligibility, offered := fixture.Eligibility(test.Card, test.CreditScore)
+			g.Expect(offered).To(gomega.Equal(test.Offered))
+			g.Expect(eligibility).To(gomega.Equal(test.Eligibility))
+		})
+	}
+}
+
+//TestFixtureValidate checks bad rules and scenarios are refused
+func TestFixtureValidate(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect((&Fixture{Rules: []EligibilityRule{{Eligibility: 11}}}).Validate()).To(gomega.MatchError("eligibility must be between 0 and 10"))
+	g.Expect((&Fixture{Rules: []EligibilityRule{{MinScore: 500, MaxScore: 400}}}).Validate()).To(gomega.MatchError("max-score must not be below min-score"))
+	g.Expect((&Fixture{Scenarios: []Scenario{{Action: "explode"}}}).Validate()).To(gomega.MatchError(`unknown scenario action "explode"`))
+	g.Expect((&Fixture{Scenarios: []Scenario{{Provider: "acme", Action: "empty"}}}).Validate()).To(gomega.MatchError(`unknown scenario provider "acme"`))
+}
@@ -0,0 +1,210 @@
+//fakeprovider emulates the CSCards and ScoredCards APIs so the whole stack can run locally, point
+//$CSCARDS_ENDPOINT at /CS/v1/cards and $SCOREDCARDS_ENDPOINT at /CS/v2/creditcards on it
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+//csCardsRequest is the body CSCards expects
+type csCardsRequest struct {
+	FullName    string `json:"fullName"`
+	DateOfBirth string `json:"dateOfBirth"`
+	CreditScore int    `json:"creditScore"`
+}
+
+//csCardResponse is a card as CSCards returns it
+type csCardResponse struct {
+	CardName    string   `json:"cardName"`
+	URL         string   `json:"url"`
+	Apr         float64  `json:"apr"`
+	Eligibility float64  `json:"eligibility"`
+	Features    []string `json:"features,omitempty"`
+}
+
+//scoredCardsRequest is the body ScoredCards expects
+type scoredCardsRequest struct {
+	FirstName   string `json:"first-name"`
+	LastName    string `json:"last-name"`
+	DateOfBirth string `json:"date-of-birth"`
+	Score       int    `json:"score"`
+	EmpStatus   string `json:"employment-status"`
+	Salary      int    `json:"salary"`
+}
+
+//scoredCardResponse is a card as ScoredCards returns it
+type scoredCardResponse struct {
+	Card           string   `json:"card"`
+	ApplyURL       string   `json:"apply-url"`
+	Apr            float64  `json:"annual-percentage-rate"`
+	ApprovalRating float64  `json:"approval-rating"`
+	Attributes     []string `json:"attributes,omitempty"`
+	IntroOffers    []string `json:"introductory-offers,omitempty"`
+}
+
+//Server answers as both providers from a fixture and counts the calls each has had for the scenarios
+type Server struct {
+	Fixture *Fixture
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+//NewServer makes a Server for a fixture
+func NewServer(fixture *Fixture) *Server {
+	return &Server{Fixture: fixture, calls: map[string]int{}}
+}
+
+//Router routes the provider endpoints and POST /reset, which zeroes the call counts
+func (server *Server) Router() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/CS/v1/cards", server.CSCards).Methods("POST")
+	r.HandleFunc("/CS/v2/creditcards", server.ScoredCards).Methods("POST")
+	r.HandleFunc("/reset", server.Reset).Methods("POST")
+	return r
+}
+
+//CSCards answers like the CSCards /cards endpoint
+func (server *Server) CSCards(w http.ResponseWriter, r *http.Request) {
+	var req csCardsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.FullName == "" || req.DateOfBirth == "" {
+		invalidRequest(w)
+		return
+	}
+	if server.play(w, "cscards", req.CreditScore) {
+		return
+	}
+
+	cards := []csCardResponse{}
+	for _, card := range server.Fixture.CSCards {
+		eligibility, ok := server.Fixture.Eligibility(card.Name, req.CreditScore)
+		if !ok {
+			continue
+		}
+		cards = append(cards, csCardResponse{
+			CardName:    card.Name,
+			URL:         card.URL,
+			Apr:         card.Apr,
+			Eligibility: eligibility,
+			Features:    card.Features,
+		})
+	}
+	writeJSON(w, cards)
+}
+
+//ScoredCards answers like the ScoredCards /creditcards endpoint, its approval rating is the eligibility out of 1
+func (server *Server) ScoredCards(w http.ResponseWriter, r *http.Request) {
+	var req scoredCardsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.FirstName == "" || req.LastName == "" || req.DateOfBirth == "" {
+		invalidRequest(w)
+		return
+	}
+	if server.play(w, "scoredcards", req.Score) {
+		return
+	}
+
+	cards := []scoredCardResponse{}
+	for _, card := range server.Fixture.ScoredCards {
+		eligibility, ok := server.Fixture.Eligibility(card.Name, req.Score)
+		if !ok {
+			continue
+		}
+		cards = append(cards, scoredCardResponse{
+			Card:           card.Name,
+			ApplyURL:       card.URL,
+			Apr:            card.Apr,
+			ApprovalRating: eligibility / 10,
+			Attributes:     card.Features,
+			IntroOffers:    card.IntroOffers,
+		})
+	}
+	writeJSON(w, cards)
+}
+
+//Reset zeroes the call counts so the after-calls scenarios start over
+func (server *Server) Reset(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	server.calls = map[string]int{}
+	server.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//play counts the call and runs the scenario that applies to it,
+//it returns true if the scenario has written the response
+func (server *Server) play(w http.ResponseWriter, provider string, creditScore int) bool {
+	server.mu.Lock()
+	calls := server.calls[provider]
+	server.calls[provider]++
+	server.mu.Unlock()
+
+	scenario := server.Fixture.Scenario(provider, calls, creditScore)
+	if scenario == nil {
+		return false
+	}
+	log.Printf("%s call %d: scenario %s", provider, calls+1, scenario.Name)
+
+	switch scenario.Action {
+	case "empty":
+		writeJSON(w, []interface{}{})
+		return true
+	case "status":
+		status := scenario.Status
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"message":%q}`, http.StatusText(status))
+		return true
+	case "malformed":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"cardName": "Malformed", "apr": `)
+		return true
+	case "delay":
+		time.Sleep(time.Duration(scenario.DelayMillis) * time.Millisecond)
+	}
+	return false
+}
+
+//invalidRequest answers like the providers do when a required field is missing
+func invalidRequest(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	fmt.Fprint(w, `{"message":"Invalid request body"}`)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	fixturePath := flag.String("fixture", "cmd/fakeprovider/fixture.json", "cards, eligibility rules and scenarios to serve")
+	scenariosPath := flag.String("scenarios", "", "scenario script replacing the fixture's scenarios, such as cmd/fakeprovider/scenarios/degrade.json")
+	flag.Parse()
+
+	fixture, err := LoadFixture(*fixturePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *scenariosPath != "" {
+		err = fixture.LoadScenarios(*scenariosPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Printf("fake CSCards and ScoredCards listening on %s with %d scenarios", *addr, len(fixture.Scenarios))
+	log.Fatal(http.ListenAndServe(*addr, NewServer(fixture).Router()))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+//post sends a body to the fake provider and returns the status and body of its answer
+func post(server *Server, path, body string) (int, string) {
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rr.Code, strings.TrimSpace(rr.Body.String())
+}
+
+//TestProviders checks both providers answer with the fixture's cards and the eligibility from its rules
+func TestProviders(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	fixture, err := LoadFixture("fixture.json")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	server := NewServer(fixture)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Path    string
+		Body    string
+		Status  int
+		Expect  string
+	}{
+		{
+			Message: "should offer both CSCards to a high score",
+			Path:    "/CS/v1/cards",
+			Body:    `{"fullName": "John Smith", "dateOfBirth": "1991/04/18", "creditScore": 700}`,
+			Status:  http.StatusOK,
+			Expect: `[{"cardName":"SuperSaver Card","url":"http://www.example.com/apply","apr":21.4,"eligibility":9},` +
+				`{"cardName":"SuperSpender Card","url":"http://www.example.com/apply","apr":19.2,"eligibility":9,"features":["Interest free purchases for 6 months"]}]`,
+		},
+		{
+			Message: "should offer one CSCard to a middling score",
+			Path:    "/CS/v1/cards",
+			Body:    `{"fullName": "John Smith", "dateOfBirth": "1991/04/18", "creditScore": 500}`,
+			Status:  http.StatusOK,
+			Expect:  `[{"cardName":"SuperSaver Card","url":"http://www.example.com/apply","apr":21.4,"eligibility":6.3}]`,
+		},
+		{
+			Message: "should rate ScoredCards out of 1",
+			Path:    "/CS/v2/creditcards",
+			Body:    `{"first-name": "John", "last-name": "Smith", "date-of-birth": "1991/04/18", "score": 700, "employment-status": "FULL_TIME", "salary": 30000}`,
+			Status:  http.StatusOK,
+			Expect: `[{"card":"ScoredCard Builder","apply-url":"http://www.example.com/apply","annual-percentage-rate":19.4,"approval-rating":0.9,` +
+				`"attributes":["Supports ApplePay"],"introductory-offers":["Interest free purchases for 1 month"]}]`,
+		},
+		{
+			Message: "should return no cards below 400 as the thin-file scenario applies",
+			Path:    "/CS/v2/creditcards",
+			Body:    `{"first-name": "John", "last-name": "Smith", "date-of-birth": "1991/04/18", "score": 350}`,
+			Status:  http.StatusOK,
+			Expect:  `[]`,
+		},
+		{
+			Message: "should fail as the body is missing a required field",
+			Path:    "/CS/v1/cards",
+			Body:    `{"creditScore": 700}`,
+			Status:  400,
+			Expect:  `{"message":"Invalid request body"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			status, body := post(server, test.Path, test.Body)
+			g.Expect(status).To(gomega.Equal(test.Status))
+			g.Expect(body).To(gomega.Equal(test.Expect))
+		})
+	}
+}
+
+//TestScenarios checks a provider degrades after the scripted number of calls and recovers on reset
+func TestScenarios(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	fixture, err := LoadFixture("fixture.json")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	fixture.Scenarios = []Scenario{
+		{Name: "scoredcards-down", Provider: "scoredcards", AfterCalls: 2, Action: "status", Status: 502},
+		{Name: "cscards-broken", Provider: "cscards", AfterCalls: 1, Action: "malformed"},
+	}
+	server := NewServer(fixture)
+	scored := `{"first-name": "John", "last-name": "Smith", "date-of-birth": "1991/04/18", "score": 700}`
+	cs := `{"fullName": "John Smith", "dateOfBirth": "1991/04/18", "creditScore": 700}`
+
+	for i := 0; i < 2; i++ {
+		status, _ := post(server, "/CS/v2/creditcards", scored)
+		g.Expect(status).To(gomega.Equal(http.StatusOK))
+	}
+	status, body := post(server, "/CS/v2/creditcards", scored)
+	g.Expect(status).To(gomega.Equal(http.StatusBadGateway))
+	g.Expect(body).To(gomega.Equal(`{"message":"Bad Gateway"}`))
+
+	//each provider counts its own calls
+	status, _ = post(server, "/CS/v1/cards", cs)
+	g.Expect(status).To(gomega.Equal(http.StatusOK))
+	_, body = post(server, "/CS/v1/cards", cs)
+	g.Expect(body).To(gomega.HavePrefix(`[{"cardName": "Malformed"`))
+
+	//a reset starts the script over
+	status, _ = post(server, "/reset", "")
+	g.Expect(status).To(gomega.Equal(http.StatusNoContent))
+	status, _ = post(server, "/CS/v2/creditcards", scored)
+	g.Expect(status).To(gomega.Equal(http.StatusOK))
+}
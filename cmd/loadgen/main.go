@@ -0,0 +1,278 @@
+//loadgen drives /v1/creditcard at a target rate and reports latency percentiles and error rates,
+//run it against the service pointed at cmd/fakeprovider so the partner APIs are not the bottleneck
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//defaultBody is the applicant posted when no -body file is given
+const defaultBody = `{"firstname": "John", "lastname": "Smith", "dob": "1991/04/18", "credit-score": 500, "employment-status": "FULL_TIME", "salary": 30000}`
+
+//Options is what to drive and how hard
+type Options struct {
+	URL         string
+	Body        []byte
+	APIKey      string
+	RPS         float64
+	Duration    time.Duration
+	MaxInFlight int
+	Timeout     time.Duration
+}
+
+//Result is the outcome of a run, one is saved per line of the results file
+type Result struct {
+	Label       string         `json:"label"`
+	Time        time.Time      `json:"time"`
+	URL         string         `json:"url"`
+	TargetRPS   float64        `json:"target-rps"`
+	AchievedRPS float64        `json:"achieved-rps"`
+	Seconds     float64        `json:"seconds"`
+	Requests    int            `json:"requests"`
+	Errors      int            `json:"errors"`
+	Dropped     int            `json:"dropped"`
+	ErrorRate   float64        `json:"error-rate"`
+	Statuses    map[string]int `json:"statuses"`
+	P50Millis   float64        `json:"p50-ms"`
+	P95Millis   float64        `json:"p95-ms"`
+	P99Millis   float64        `json:"p99-ms"`
+	MaxMillis   float64        `json:"max-ms"`
+}
+
+//sample is one request's latency and outcome, status is "error" if no response came back
+type sample struct {
+	latency time.Duration
+	status  string
+}
+
+//Run sends requests at the target rate for the duration, it does not wait for slow responses before sending
+//the next one so latency is not hidden, but drops a request if MaxInFlight are already outstanding
+func Run(opts Options) Result {
+	client := &http.Client{Timeout: opts.Timeout}
+	samples := make(chan sample, 1024)
+	inFlight := make(chan struct{}, opts.MaxInFlight)
+	var wg sync.WaitGroup
+
+	result := Result{URL: opts.URL, TargetRPS: opts.RPS, Statuses: map[string]int{}}
+	var latencies []time.Duration
+	collected := make(chan struct{})
+	go func() {
+		for s := range samples {
+			result.Requests++
+			result.Statuses[s.status]++
+			if s.status == "error" || !strings.HasPrefix(s.status, "2") {
+				result.Errors++
+			}
+			latencies = append(latencies, s.latency)
+		}
+		close(collected)
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.RPS))
+	deadline := time.After(opts.Duration)
+loop:
+	for {
+		select {
+		case <-deadline:
+			break loop
+		case <-ticker.C:
+			select {
+			case inFlight <- struct{}{}:
+			default:
+				result.Dropped++
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				samples <- send(client, opts)
+				<-inFlight
+			}()
+		}
+	}
+	ticker.Stop()
+	wg.Wait()
+	close(samples)
+	<-collected
+
+	elapsed := time.Since(start)
+	result.Seconds = elapsed.Seconds()
+	result.AchievedRPS = float64(result.Requests) / elapsed.Seconds()
+	if result.Requests > 0 {
+		result.ErrorRate = float64(result.Errors) / float64(result.Requests)
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	result.P50Millis = millis(percentile(latencies, 50))
+	result.P95Millis = millis(percentile(latencies, 95))
+	result.P99Millis = millis(percentile(latencies, 99))
+	result.MaxMillis = millis(percentile(latencies, 100))
+	return result
+}
+
+//send makes one request and reads the whole response so the latency includes the body
+func send(client *http.Client, opts Options) sample {
+	req, _ := http.NewRequest("POST", opts.URL, bytes.NewReader(opts.Body))
+	req.Header.Set("Content-Type", "application/json")
+	if opts.APIKey != "" {
+		req.Header.Set("X-API-Key", opts.APIKey)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return sample{latency: time.Since(start), status: "error"}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return sample{latency: time.Since(start), status: strconv.Itoa(resp.StatusCode)}
+}
+
+//percentile returns the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func millis(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*100) / 100
+}
+
+//Report prints a result, and how it compares to a previous one if there is one
+func Report(w io.Writer, result Result, previous *Result) {
+	fmt.Fprintf(w, "%s: %d requests in %.1fs, %.1f/s of %.1f/s target, %d dropped\n",
+		result.Label, result.Requests, result.Seconds, result.AchievedRPS, result.TargetRPS, result.Dropped)
+	fmt.Fprintf(w, "latency ms: p50 %.2f, p95 %.2f, p99 %.2f, max %.2f\n",
+		result.P50Millis, result.P95Millis, result.P99Millis, result.MaxMillis)
+
+	statuses := make([]string, 0, len(result.Statuses))
+	for status := range result.Statuses {
+		statuses = append(statuses, fmt.Sprintf("%s x%d", status, result.Statuses[status]))
+	}
+	sort.Strings(statuses)
+	fmt.Fprintf(w, "errors: %d (%.2f%%), statuses: %s\n", result.Errors, result.ErrorRate*100, strings.Join(statuses, ", "))
+
+	if previous == nil {
+		return
+	}
+	fmt.Fprintf(w, "against %s: p50 %+.2f, p95 %+.2f, p99 %+.2f ms, error rate %+.2f%%\n", previous.Label,
+		result.P50Millis-previous.P50Millis, result.P95Millis-previous.P95Millis, result.P99Millis-previous.P99Millis,
+		(result.ErrorRate-previous.ErrorRate)*100)
+}
+
+//LastResult returns the last result saved in a results file, nil if there is none
+func LastResult(path string) (*Result, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open results %s", path)
+	}
+	defer file.Close()
+
+	var last *Result
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		result := &Result{}
+		if json.Unmarshal(scanner.Bytes(), result) == nil {
+			last = result
+		}
+	}
+	return last, nil
+}
+
+//SaveResult appends a result to a results file
+func SaveResult(path string, result Result) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open results %s", path)
+	}
+	defer file.Close()
+	line, _ := json.Marshal(result)
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("unable to save results %s", path)
+	}
+	return nil
+}
+
+//gitLabel names a run after the commit it was made on
+func gitLabel() string {
+	out, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output()
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:8080/v1/creditcard", "endpoint to drive")
+	bodyPath := flag.String("body", "", "file with the request body, a sample applicant by default")
+	apiKey := flag.String("api-key", os.Getenv("LOADGEN_API_KEY"), "X-API-Key to send")
+	rps := flag.Float64("rps", 50, "requests per second to send")
+	duration := flag.Duration("duration", 30*time.Second, "how long to send for")
+	maxInFlight := flag.Int("max-in-flight", 500, "outstanding requests before new ones are dropped")
+	timeout := flag.Duration("timeout", 10*time.Second, "per request timeout")
+	label := flag.String("label", "", "name of the run in the results, the git commit by default")
+	out := flag.String("out", "", "results file to append to and compare against, such as loadtest.jsonl")
+	flag.Parse()
+
+	body := []byte(defaultBody)
+	if *bodyPath != "" {
+		var err error
+		body, err = ioutil.ReadFile(*bodyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to read body %s\n", *bodyPath)
+			os.Exit(2)
+		}
+	}
+	if *rps <= 0 || *maxInFlight <= 0 {
+		fmt.Fprintln(os.Stderr, "rps and max-in-flight must be positive")
+		os.Exit(2)
+	}
+	if *label == "" {
+		*label = gitLabel()
+	}
+
+	result := Run(Options{URL: *url, Body: body, APIKey: *apiKey, RPS: *rps, Duration: *duration, MaxInFlight: *maxInFlight, Timeout: *timeout})
+	result.Label = *label
+	result.Time = time.Now().UTC()
+
+	var previous *Result
+	if *out != "" {
+		var err error
+		previous, err = LastResult(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		err = SaveResult(*out, result)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	Report(os.Stdout, result, previous)
+}
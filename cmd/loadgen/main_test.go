@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+//TestRun checks requests are sent at the target rate and the failures are counted
+func TestRun(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		g.Expect(string(body)).To(gomega.Equal(defaultBody))
+		g.Expect(r.Header.Get("X-API-Key")).To(gomega.Equal("key"))
+		//every fourth request fails
+		if atomic.AddInt32(&calls, 1)%4 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	result := Run(Options{URL: server.URL, Body: []byte(defaultBody), APIKey: "key", RPS: 200, Duration: 500 * time.Millisecond, MaxInFlight: 10, Timeout: time.Second})
+	g.Expect(result.Requests).To(gomega.BeNumerically("~", 100, 20))
+	g.Expect(result.Errors).To(gomega.Equal(result.Requests / 4))
+	g.Expect(result.Statuses["500"]).To(gomega.Equal(result.Errors))
+	g.Expect(result.ErrorRate).To(gomega.BeNumerically("~", 0.25, 0.02))
+	g.Expect(result.P50Millis).To(gomega.BeNumerically("<=", result.P99Millis))
+	g.Expect(result.P99Millis).To(gomega.BeNumerically("<=", result.MaxMillis))
+}
+
+//TestPercentile checks the nearest-rank percentiles
+func TestPercentile(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	g.Expect(percentile(latencies, 50)).To(gomega.Equal(50 * time.Millisecond))
+	g.Expect(percentile(latencies, 95)).To(gomega.Equal(95 * time.Millisecond))
+	g.Expect(percentile(latencies, 99)).To(gomega.Equal(99 * time.Millisecond))
+	g.Expect(percentile(latencies, 100)).To(gomega.Equal(100 * time.Millisecond))
+	g.Expect(percentile(nil, 50)).To(gomega.BeZero())
+}
+
+//TestResultsFile checks results are saved and the next run is compared against the last one
+func TestResultsFile(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "loadgen")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "loadtest.jsonl")
+
+	previous, err := LastResult(path)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(previous).To(gomega.BeNil())
+
+	g.Expect(SaveResult(path, Result{Label: "abc123", P50Millis: 10, P95Millis: 20, P99Millis: 30})).To(gomega.Succeed())
+	g.Expect(SaveResult(path, Result{Label: "def456", P50Millis: 12, P95Millis: 18, P99Millis: 40, ErrorRate: 0.01})).To(gomega.Succeed())
+	previous, err = LastResult(path)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(previous.Label).To(gomega.Equal("def456"))
+
+	var out bytes.Buffer
+	Report(&out, Result{
+		Label: "fed789", Seconds: 10, Requests: 500, AchievedRPS: 50, TargetRPS: 50,
+		Errors: 5, ErrorRate: 0.01, Statuses: map[string]int{"200": 495, "error": 5},
+		P50Millis: 11, P95Millis: 19, P99Millis: 35, MaxMillis: 50,
+	}, previous)
+	g.Expect(out.String()).To(gomega.Equal(`fed789: 500 requests in 10.0s, 50.0/s of 50.0/s target, 0 dropped
+latency ms: p50 11.00, p95 19.00, p99 35.00, max 50.00
+errors: 5 (1.00%), statuses: 200 x495, error x5
+against def456: p50 -1.00, p95 +1.00, p99 -5.00 ms, error rate +0.00%
+`))
+}
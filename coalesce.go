@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"strings"
+	"sync"
+)
+
+//CoalescedCalls counts, by provider, the calls that shared an identical call already in flight instead of making their own,
+//it is served at /v1/admin/metrics
+var CoalescedCalls = expvar.NewMap("coalesced_calls")
+
+//flight is a provider call shared by every caller asking for the same applicant while it runs
+type flight struct {
+	done    chan struct{}
+	result  interface{}
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+//Coalescer makes concurrent identical provider calls share one upstream call, like a double submitted form
+//or a retry from the frontend while the first request is still waiting
+type Coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+//NewCoalescer creates a coalescer with nothing in flight
+func NewCoalescer() *Coalescer {
+	return &Coalescer{flights: map[string]*flight{}}
+}
+
+//DefaultCoalescer is the coalescer the provider calls go through
+var DefaultCoalescer = NewCoalescer()
+
+//Do runs call for the key unless it is already running, in which case it waits for that call's result.
+//The call gets its own context, cancelled once every caller waiting for it has given up, so one caller
+//going away does not fail the others. shared reports whether the result came from another caller's call
+func (c *Coalescer) Do(ctx context.Context, key string, call func(ctx context.Context) (interface{}, error)) (result interface{}, shared bool, err error) {
+	c.mu.Lock()
+	f, shared := c.flights[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(context.Background())
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		c.flights[key] = f
+		go func() {
+			f.result, f.err = call(callCtx)
+			c.forget(key, f)
+			cancel()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.result, shared, f.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			//nobody wants the answer any more, later callers start a new call rather than join a cancelled one
+			f.cancel()
+			if c.flights[key] == f {
+				delete(c.flights, key)
+			}
+		}
+		c.mu.Unlock()
+		return nil, shared, ctx.Err()
+	}
+}
+
+//forget removes the finished call so the next caller makes a fresh one
+func (c *Coalescer) forget(key string, f *flight) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.flights[key] == f {
+		delete(c.flights, key)
+	}
+}
+
+//coalesceKey is the provider and a hash of the applicant, normalized so the same person typed twice gives the same key
+func coalesceKey(provider string, userInfo *UserInfo) string {
+	normalized := UserInfo{
+		FirstName:   strings.ToLower(strings.TrimSpace(userInfo.FirstName)),
+		LastName:    strings.ToLower(strings.TrimSpace(userInfo.LastName)),
+		DOB:         strings.TrimSpace(userInfo.DOB),
+		CreditScore: userInfo.CreditScore,
+		EmpStatus:   strings.ToUpper(strings.TrimSpace(userInfo.EmpStatus)),
+		Salary:      userInfo.Salary,
+	}
+	body, _ := json.Marshal(normalized)
+	sum := sha256.Sum256(body)
+	return provider + ":" + hex.EncodeToString(sum[:])
+}
+
+//coalesceFetch runs a provider call through DefaultCoalescer and counts the calls that were shared
+func coalesceFetch(ctx context.Context, provider string, userInfo *UserInfo, fetch func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	result, shared, err := DefaultCoalescer.Do(ctx, coalesceKey(provider, userInfo), fetch)
+	if shared {
+		CoalescedCalls.Add(provider, 1)
+	}
+	return result, err
+}
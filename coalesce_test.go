@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+//TestCoalescedFetch checks concurrent calls for the same applicant share one upstream call and other applicants do not
+func TestCoalescedFetch(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//CSCards holds every answer until all the calls have been made
+	var upstream int32
+	release := make(chan struct{})
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstream, 1)
+		<-release
+		fmt.Fprint(w, csCardsFixture)
+	}))
+	defer cs.Close()
+	endpoint := CSCardsEndpoint
+	CSCardsEndpoint = cs.URL
+	defer func() { CSCardsEndpoint = endpoint }()
+
+	before, _ := CoalescedCalls.Get("CSCards").(interface{ Value() int64 })
+	coalescedBefore := int64(0)
+	if before != nil {
+		coalescedBefore = before.Value()
+	}
+
+	//the same applicant typed with different spacing and case, and a different applicant
+	same := testApplicant
+	same.FirstName = " " + same.FirstName + " "
+	other := testApplicant
+	other.CreditScore++
+	applicants := []UserInfo{testApplicant, testApplicant, same, testApplicant, other}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(applicants))
+	cards := make([][]CSCardResponse, len(applicants))
+	for i := range applicants {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cards[i], errs[i] = applicants[i].FetchCSCards(context.Background())
+		}(i)
+	}
+	g.Eventually(func() int32 { return atomic.LoadInt32(&upstream) }).Should(gomega.Equal(int32(2)))
+	//gives the callers time to join the calls in flight before they are answered
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range applicants {
+		g.Expect(errs[i]).NotTo(gomega.HaveOccurred())
+		g.Expect(cards[i]).To(gomega.HaveLen(2))
+	}
+	g.Expect(atomic.LoadInt32(&upstream)).To(gomega.Equal(int32(2)))
+	g.Expect(CoalescedCalls.Get("CSCards").(interface{ Value() int64 }).Value()).To(gomega.Equal(coalescedBefore + 3))
+}
+
+//TestCoalescerCancel checks a caller giving up does not fail the others, and the call stops once all have given up
+func TestCoalescerCancel(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	coalescer := NewCoalescer()
+	release := make(chan struct{})
+	stopped := make(chan error, 1)
+	call := func(ctx context.Context) (interface{}, error) {
+		select {
+		case <-release:
+			return "cards", nil
+		case <-ctx.Done():
+			stopped <- ctx.Err()
+			return nil, ctx.Err()
+		}
+	}
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message   string
+		CancelAll bool
+	}{
+		{Message: "should answer the caller still waiting", CancelAll: false},
+		{Message: "should cancel the call once every caller has gone", CancelAll: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			leaving, leave := context.WithCancel(context.Background())
+			staying, stay := context.WithCancel(context.Background())
+			defer stay()
+			results := make(chan error, 2)
+			go func() {
+				_, _, err := coalescer.Do(leaving, "key", call)
+				results <- err
+			}()
+			time.Sleep(10 * time.Millisecond)
+			go func() {
+				result, shared, err := coalescer.Do(staying, "key", call)
+				if err == nil && (result != "cards" || !shared) {
+					err = fmt.Errorf("got %v shared %v", result, shared)
+				}
+				results <- err
+			}()
+			time.Sleep(10 * time.Millisecond)
+
+			leave()
+			g.Expect(<-results).To(gomega.Equal(context.Canceled))
+			if test.CancelAll {
+				stay()
+				g.Expect(<-results).To(gomega.Equal(context.Canceled))
+				g.Eventually(stopped).Should(gomega.Receive(gomega.Equal(context.Canceled)))
+				return
+			}
+			release <- struct{}{}
+			g.Expect(<-results).NotTo(gomega.HaveOccurred())
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+//Config holds the service settings, loaded from the JSON file named by $CONFIG_FILE
+type Config struct {
+	//Environment is production unless set otherwise, by the file or $ENVIRONMENT
+	Environment  string            `json:"environment"`
+	RateLimit    RateLimitConfig   `json:"rate-limit"`
+	Auth         AuthConfig        `json:"auth"`
+	JWT          JWTConfig         `json:"jwt"`
+	Audit        AuditConfig       `json:"audit"`
+	Providers    []AdapterConfig   `json:"providers"`
+	Faults       FaultConfig       `json:"faults"`
+	Batch        BatchConfig       `json:"batch"`
+	Jobs         JobsConfig        `json:"jobs"`
+	ProviderHTTP HTTPConfig        `json:"provider-http"`
+	Idempotency  IdempotencyConfig `json:"idempotency"`
+	Decoding     DecodeConfig      `json:"decoding"`
+}
+
+//DefaultConfig returns the settings used when no config file is given
+func DefaultConfig() *Config {
+	return &Config{
+		Environment: envOrDefault("ENVIRONMENT", "production"),
+		RateLimit: RateLimitConfig{
+			Enabled:           true,
+			DefaultTier:       "standard",
+			TrustForwardedFor: true,
+			Tiers: map[string]RateLimitTier{
+				"standard": {RequestsPerMinute: 60, Burst: 10},
+			},
+		},
+		JWT: JWTConfig{
+			DefaultTier: "standard",
+			RouteScopes: map[string][]string{
+				"/v1/creditcard":        {"cards:recommend"},
+				"/v1/creditcard/stream": {"cards:recommend"},
+				"/v1/creditcard/batch":  {"cards:batch"},
+				"/v1/jobs/creditcard":   {"cards:recommend"},
+				"/v1/jobs/{id}":         {"cards:recommend"},
+				"/v1/admin/keys/reload": {"admin:*"},
+				"/v1/admin/metrics":     {"admin:*"},
+			},
+		},
+		Batch: BatchConfig{
+			Concurrency:  8,
+			MaxItems:     10000,
+			MaxBodyBytes: 16 << 20,
+			ProviderLimits: map[string]RateLimitTier{
+				"CSCards":     {RequestsPerMinute: 600, Burst: 20},
+				"ScoredCards": {RequestsPerMinute: 600, Burst: 20},
+			},
+		},
+		Jobs: JobsConfig{
+			Workers:         4,
+			QueueSize:       1000,
+			TTLSeconds:      3600,
+			WebhookSecret:   os.Getenv("JOBS_WEBHOOK_SECRET"),
+			WebhookAttempts: 3,
+		},
+		Decoding: DecodeConfig{
+			MaxBodyBytes: defaultMaxBodyBytes,
+		},
+		Idempotency: IdempotencyConfig{
+			TTLSeconds: 86400,
+		},
+		Audit: AuditConfig{
+			Fsync:        "always",
+			PseudonymKey: os.Getenv("AUDIT_PSEUDONYM_KEY"),
+		},
+	}
+}
+
+//LoadConfig reads the config file at path on top of the defaults, an empty path returns the defaults
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file %s", path)
+	}
+	err = json.Unmarshal(body, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+//Validate checks the settings are consistent with each other
+func (cfg *Config) Validate() error {
+	err := cfg.RateLimit.Validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.JWT.Validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Audit.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.Faults.Validate(cfg.Environment)
+	if err != nil {
+		return err
+	}
+	err = cfg.Batch.Validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Jobs.Validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.ProviderHTTP.Validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Idempotency.Validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Decoding.Validate()
+	if err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, provider := range cfg.Providers {
+		err = provider.Validate()
+		if err != nil {
+			return err
+		}
+		if names[provider.Name] {
+			return fmt.Errorf("provider adapter %s is defined twice", provider.Name)
+		}
+		names[provider.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/heroku/go-getting-started/client"
+)
+
+//RequestTimeoutHeader lets a client with a timeout of its own shrink the time the providers are given,
+//as seconds or a duration such as 1500ms
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+//RequestDeadlineHeader is the same as an RFC 3339 time, for clients passing on a deadline they were given
+const RequestDeadlineHeader = "X-Request-Deadline"
+
+//RequestDeadline is the middleware that gives the request's context the deadline the client asked for,
+//the context is also done when the client goes away, so every provider call made with it stops then
+func RequestDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok, err := requestDeadline(r, time.Now())
+		if err != nil {
+			writeProblem(w, r, client.ProblemInvalidRequest, 400, "%v", err)
+			return
+		}
+		if ok {
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//requestDeadline reads the deadline headers, the earlier deadline wins when both are sent
+func requestDeadline(r *http.Request, now time.Time) (time.Time, bool, error) {
+	var deadline time.Time
+	ok := false
+	if header := r.Header.Get(RequestTimeoutHeader); header != "" {
+		timeout, err := parseRequestTimeout(header)
+		if err != nil {
+			return deadline, false, err
+		}
+		deadline, ok = now.Add(timeout), true
+	}
+	if header := r.Header.Get(RequestDeadlineHeader); header != "" {
+		at, err := time.Parse(time.RFC3339Nano, header)
+		if err != nil {
+			return deadline, false, fmt.Errorf("%s must be an RFC 3339 time", RequestDeadlineHeader)
+		}
+		if !ok || at.Before(deadline) {
+			deadline, ok = at, true
+		}
+	}
+	return deadline, ok, nil
+}
+
+//parseRequestTimeout reads a timeout given as seconds or as a duration
+func parseRequestTimeout(header string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(header)
+	if err != nil {
+		seconds, floatErr := strconv.ParseFloat(header, 64)
+		timeout, err = time.Duration(seconds*float64(time.Second)), floatErr
+	}
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("%s must be a positive number of seconds or a duration such as 1500ms", RequestTimeoutHeader)
+	}
+	return timeout, nil
+}
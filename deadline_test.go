@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+//TestRequestDeadline checks the timeout and deadline headers are read and the earlier one wins
+func TestRequestDeadline(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message  string
+		Timeout  string
+		Deadline string
+		Expected time.Time
+		OK       bool
+		Error    string
+	}{
+		{Message: "should have no deadline without the headers", OK: false},
+		{Message: "should read the timeout in seconds", Timeout: "1.5", Expected: now.Add(1500 * time.Millisecond), OK: true},
+		{Message: "should read the timeout as a duration", Timeout: "250ms", Expected: now.Add(250 * time.Millisecond), OK: true},
+		{Message: "should read the deadline", Deadline: "2026-10-18T09:00:02Z", Expected: now.Add(2 * time.Second), OK: true},
+		{Message: "should take the earlier deadline", Timeout: "3s", Deadline: "2026-10-18T09:00:02Z", Expected: now.Add(2 * time.Second), OK: true},
+		{Message: "should fail as the timeout is negative", Timeout: "-1s", Error: "X-Request-Timeout must be a positive number of seconds or a duration such as 1500ms"},
+		{Message: "should fail as the timeout is not a number", Timeout: "soon", Error: "X-Request-Timeout must be a positive number of seconds or a duration such as 1500ms"},
+		{Message: "should fail as the deadline is not a time", Deadline: "tomorrow", Error: "X-Request-Deadline must be an RFC 3339 time"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/creditcard", nil)
+			req.Header.Set(RequestTimeoutHeader, test.Timeout)
+			req.Header.Set(RequestDeadlineHeader, test.Deadline)
+			deadline, ok, err := requestDeadline(req, now)
+			if test.Error != "" {
+				g.Expect(err).To(gomega.MatchError(test.Error))
+				return
+			}
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(ok).To(gomega.Equal(test.OK))
+			g.Expect(deadline.Equal(test.Expected)).To(gomega.BeTrue())
+		})
+	}
+}
+
+//TestHandlerDeadline checks a client's timeout stops the provider calls and is answered with 504,
+//and that a client going away stops them without an answer, both counted as cancellations rather than failures
+func TestHandlerDeadline(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	//ScoredCards answers only once its request has been cancelled
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//reads the body so the server notices when the connection is closed
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer hanging.Close()
+	ScoredCardsEndpoint = hanging.URL
+	handler := RequestDeadline(Handler(DecodeConfig{}))
+
+	before := providerCallCount("ScoredCards", "deadline-exceeded")
+	req := httptest.NewRequest(http.MethodPost, "/v1/creditcard", strings.NewReader(jobApplicant))
+	req.Header.Set(RequestTimeoutHeader, "50ms")
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(rr, req)
+	g.Expect(time.Since(start)).To(gomega.BeNumerically("<", time.Second))
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusGatewayTimeout))
+	g.Expect(rr.Body.String()).To(gomega.Equal("the providers did not answer within the request timeout"))
+	g.Expect(providerCallCount("ScoredCards", "deadline-exceeded")).To(gomega.Equal(before + 1))
+
+	failed := providerCallCount("ScoredCards", "failed")
+	before = providerCallCount("ScoredCards", "cancelled")
+	ctx, cancel := context.WithCancel(context.Background())
+	req = httptest.NewRequest(http.MethodPost, "/v1/creditcard", strings.NewReader(jobApplicant)).WithContext(ctx)
+	rr = httptest.NewRecorder()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	handler.ServeHTTP(rr, req)
+	g.Expect(rr.Body.String()).To(gomega.BeEmpty())
+	g.Expect(providerCallCount("ScoredCards", "cancelled")).To(gomega.Equal(before + 1))
+	g.Expect(providerCallCount("ScoredCards", "failed")).To(gomega.Equal(failed))
+
+	//a timeout that cannot be read is refused before the providers are asked
+	req = httptest.NewRequest(http.MethodPost, "/v1/creditcard", strings.NewReader(jobApplicant))
+	req.Header.Set(RequestTimeoutHeader, "soon")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusBadRequest))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/heroku/go-getting-started/client"
+)
+
+//DecodeConfig is the decoding section of the config file
+type DecodeConfig struct {
+	//MaxBodyBytes is the largest applicant body accepted, a larger one is refused with 413
+	MaxBodyBytes int64 `json:"max-body-bytes"`
+	//DisallowUnknownFields refuses fields the applicant does not have, so a typo such as creditScore is not
+	//sent on to the providers as a score of 0. It is off by default as clients that send extra fields would be refused
+	DisallowUnknownFields bool `json:"disallow-unknown-fields"`
+}
+
+//defaultMaxBodyBytes is the body limit of a DecodeConfig that does not set one
+const defaultMaxBodyBytes = 64 << 10
+
+//Validate checks bodies can be read at all
+func (cfg DecodeConfig) Validate() error {
+	if cfg.MaxBodyBytes < 1 {
+		return fmt.Errorf("decoding needs a positive max-body-bytes")
+	}
+	return nil
+}
+
+//maxBodyBytes is the body limit, the zero DecodeConfig gets the default one
+func (cfg DecodeConfig) maxBodyBytes() int64 {
+	if cfg.MaxBodyBytes < 1 {
+		return defaultMaxBodyBytes
+	}
+	return cfg.MaxBodyBytes
+}
+
+//BodyError is a request body that cannot be used, Status is the answer it gets and Message says what to fix
+type BodyError struct {
+	Status  int
+	Message string
+}
+
+func (err *BodyError) Error() string {
+	return err.Message
+}
+
+//writeBodyError answers with the problem for an error of readBody or decodeJSON
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	bodyErr, ok := err.(*BodyError)
+	if !ok {
+		bodyErr = &BodyError{Status: 400, Message: "please enter user info"}
+	}
+	problemType := client.ProblemInvalidRequest
+	switch bodyErr.Status {
+	case http.StatusRequestEntityTooLarge:
+		problemType = client.ProblemPayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		problemType = client.ProblemUnsupportedMediaType
+	}
+	writeProblem(w, r, problemType, bodyErr.Status, "%s", bodyErr.Message)
+}
+
+//readBody reads a request body of at most maxBytes sent as one of the media types, a body without a Content-Type
+//is taken as the first of them
+func readBody(r *http.Request, maxBytes int64, mediaTypes ...string) ([]byte, error) {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !acceptedMediaType(mediaType, mediaTypes) {
+			return nil, &BodyError{Status: http.StatusUnsupportedMediaType, Message: fmt.Sprintf("please send the body as %s, not %s", strings.Join(mediaTypes, " or "), contentType)}
+		}
+	}
+
+	//reads one byte more than allowed to tell a body of exactly maxBytes from a larger one
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	if err != nil {
+		return nil, &BodyError{Status: 400, Message: "please enter user info"}
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, &BodyError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("please enter a body of at most %d bytes", maxBytes)}
+	}
+	return body, nil
+}
+
+//acceptedMediaType reports whether the media type is one of the accepted ones, JSON also accepts any +json type
+func acceptedMediaType(mediaType string, accepted []string) bool {
+	for _, a := range accepted {
+		if mediaType == a || (a == "application/json" && strings.HasSuffix(mediaType, "+json")) {
+			return true
+		}
+	}
+	return false
+}
+
+//decodeJSON decodes a single JSON value into v, errors name the field and the line and column that are wrong
+func decodeJSON(body []byte, v interface{}, disallowUnknownFields bool) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &BodyError{Status: 400, Message: "please enter user info"}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	err := decoder.Decode(v)
+	if err != nil {
+		return &BodyError{Status: 400, Message: decodeErrorMessage(body, v, err)}
+	}
+	//a second value after the first is most likely two applicants sent to an endpoint that takes one
+	var extra json.RawMessage
+	if decoder.Decode(&extra) != io.EOF {
+		return &BodyError{Status: 400, Message: "please enter a single JSON object"}
+	}
+	return nil
+}
+
+//decodeErrorMessage explains a decoding error in the terms of the JSON the client sent
+func decodeErrorMessage(body []byte, v interface{}, err error) string {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return fmt.Sprintf("please enter the body in right JSON format, %s at %s", e.Error(), position(body, e.Offset))
+	case *json.UnmarshalTypeError:
+		if e.Field == "" {
+			return fmt.Sprintf("please enter the body as a JSON object, not %s", e.Value)
+		}
+		return fmt.Sprintf("%s should be %s, not %s, at %s", e.Field, jsonKind(e.Type), e.Value, position(body, e.Offset))
+	}
+	if err == io.ErrUnexpectedEOF {
+		return fmt.Sprintf("please enter the body in right JSON format, it ends early at %s", position(body, int64(len(body))))
+	}
+	//the decoder has no error type for unknown fields, its message is json: unknown field "name"
+	if name := strings.TrimPrefix(err.Error(), "json: unknown field "); name != err.Error() {
+		name = strings.Trim(name, `"`)
+		message := fmt.Sprintf("unknown field %s", name)
+		if offset := bytes.Index(body, []byte(`"`+name+`"`)); offset >= 0 {
+			message += " at " + position(body, int64(offset+1))
+		}
+		if suggestion := closestField(name, v); suggestion != "" {
+			message += ", did you mean " + suggestion + "?"
+		}
+		return message
+	}
+	return "please enter the body in right JSON format"
+}
+
+//position is the line and column of the byte before offset, as editors count them from 1
+func position(body []byte, offset int64) string {
+	if offset > int64(len(body)) {
+		offset = int64(len(body))
+	}
+	line, column := 1, 1
+	for _, b := range body[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	if column > 1 {
+		column--
+	}
+	return fmt.Sprintf("line %d column %d", line, column)
+}
+
+//jsonKind describes the JSON a Go type is decoded from
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	}
+	return "an object"
+}
+
+//closestField is the field of v's struct the unknown name was probably meant to be, ignoring case, dashes and underscores
+func closestField(name string, v interface{}) string {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return ""
+	}
+	normalize := strings.NewReplacer("-", "", "_", "")
+	wanted := normalize.Replace(strings.ToLower(name))
+	for i := 0; i < t.NumField(); i++ {
+		field := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if field != "" && field != "-" && normalize.Replace(strings.ToLower(field)) == wanted {
+			return field
+		}
+	}
+	return ""
+}
+
+//DecodeApplicant reads the applicant in the request body as the decoding config says
+func DecodeApplicant(r *http.Request, cfg DecodeConfig) (UserInfo, error) {
+	var userInfo UserInfo
+	body, err := readBody(r, cfg.maxBodyBytes(), "application/json")
+	if err != nil {
+		return userInfo, err
+	}
+	err = decodeJSON(body, &userInfo, cfg.DisallowUnknownFields)
+	return userInfo, err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/heroku/go-getting-started/client"
+	"github.com/onsi/gomega"
+)
+
+//TestDecodeApplicant checks bodies are limited to JSON of a set size and errors point at what is wrong
+func TestDecodeApplicant(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	decoding := DecodeConfig{MaxBodyBytes: 200, DisallowUnknownFields: true}
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message     string
+		ContentType string
+		Body        string
+		Status      int
+		Error       string
+	}{
+		{Message: "should decode the applicant", ContentType: "application/json; charset=utf-8", Body: `{"firstname": "John", "credit-score": 500}`},
+		{Message: "should decode the applicant without a content type", ContentType: "", Body: `{"firstname": "John", "credit-score": 500}`},
+		{Message: "should fail as the body is a form", ContentType: "application/x-www-form-urlencoded", Body: "firstname=John", Status: 415,
+			Error: "please send the body as application/json, not application/x-www-form-urlencoded"},
+		{Message: "should fail as the body is too large", ContentType: "application/json", Body: `{"firstname": "` + strings.Repeat("J", 200) + `"}`, Status: 413,
+			Error: "please enter a body of at most 200 bytes"},
+		{Message: "should fail as the body is empty", ContentType: "application/json", Body: " ", Status: 400, Error: "please enter user info"},
+		{Message: "should fail naming the misspelt field", ContentType: "application/json", Body: "{\n  \"firstname\": \"John\",\n  \"creditScore\": 500\n}", Status: 400,
+			Error: "unknown field creditScore at line 3 column 3, did you mean credit-score?"},
+		{Message: "should fail naming the field of the wrong type", ContentType: "application/json", Body: `{"firstname": "John", "credit-score": "500"}`, Status: 400,
+			Error: "credit-score should be a whole number, not string, at line 1 column 43"},
+		{Message: "should fail pointing at the broken JSON", ContentType: "application/json", Body: "{\n  \"firstname\": \"John\",\n}", Status: 400,
+			Error: "please enter the body in right JSON format, invalid character '}' looking for beginning of object key string at line 3 column 1"},
+		{Message: "should fail as the JSON ends early", ContentType: "application/json", Body: `{"firstname": "John"`, Status: 400,
+			Error: "please enter the body in right JSON format, it ends early at line 1 column 20"},
+		{Message: "should fail as the body is not an object", ContentType: "application/json", Body: `["John"]`, Status: 400,
+			Error: "please enter the body as a JSON object, not array"},
+		{Message: "should fail as the body holds two applicants", ContentType: "application/json", Body: `{"firstname": "John"} {"firstname": "Jane"}`, Status: 400,
+			Error: "please enter a single JSON object"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/creditcard", strings.NewReader(test.Body))
+			if test.ContentType != "" {
+				req.Header.Set("Content-Type", test.ContentType)
+			}
+			userInfo, err := DecodeApplicant(req, decoding)
+			if test.Status == 0 {
+				g.Expect(err).NotTo(gomega.HaveOccurred())
+				g.Expect(userInfo.CreditScore).To(gomega.Equal(500))
+				return
+			}
+			g.Expect(err).To(gomega.HaveOccurred())
+			g.Expect(err.(*BodyError).Status).To(gomega.Equal(test.Status))
+			g.Expect(err.Error()).To(gomega.Equal(test.Error))
+
+			//the handlers answer with the same status and message
+			rr := httptest.NewRecorder()
+			writeBodyError(rr, req, err)
+			g.Expect(rr.Code).To(gomega.Equal(test.Status))
+			g.Expect(rr.Body.String()).To(gomega.Equal(test.Error))
+		})
+	}
+
+	//each status is answered with its own problem type
+	for status, problemType := range map[int]string{400: client.ProblemInvalidRequest, 413: client.ProblemPayloadTooLarge, 415: client.ProblemUnsupportedMediaType} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/creditcard", nil)
+		req.Header.Set("Accept", client.ProblemContentType)
+		rr := httptest.NewRecorder()
+		writeBodyError(rr, req, &BodyError{Status: status, Message: "please enter user info"})
+		var problem client.Problem
+		g.Expect(json.Unmarshal(rr.Body.Bytes(), &problem)).To(gomega.Succeed())
+		g.Expect(problem.Status).To(gomega.Equal(status))
+		g.Expect(problem.Type).To(gomega.Equal(problemType))
+	}
+
+	//unknown fields are ignored when the option is off, as it is by default
+	userInfo, err := DecodeApplicant(httptest.NewRequest(http.MethodPost, "/v1/creditcard", strings.NewReader(`{"creditScore": 500, "salary": 30000}`)), DefaultConfig().Decoding)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(userInfo.Salary).To(gomega.Equal(30000))
+
+	//the zero config limits bodies to the default size
+	_, err = DecodeApplicant(httptest.NewRequest(http.MethodPost, "/v1/creditcard", strings.NewReader(strings.Repeat(" ", defaultMaxBodyBytes+1))), DecodeConfig{})
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(err.(*BodyError).Status).To(gomega.Equal(413))
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//ResponseEncoder writes the ranked cards in one format, the first media type is sent as the Content-Type
+type ResponseEncoder interface {
+	MediaTypes() []string
+	Encode(w io.Writer, creditcards []CreditCard) error
+}
+
+//ResponseEncoders are the formats the /creditcard endpoint can answer in, the first is used when
+//the client accepts anything or sends no Accept header
+var ResponseEncoders = []ResponseEncoder{JSONEncoder{}, CSVEncoder{}, XMLEncoder{}, MessagePackEncoder{}}
+
+//RegisterEncoder adds a format, it is preferred over the existing ones for the media types it lists
+func RegisterEncoder(encoder ResponseEncoder) {
+	ResponseEncoders = append([]ResponseEncoder{encoder}, ResponseEncoders...)
+}
+
+//mediaRange is one entry of an Accept header
+type mediaRange struct {
+	mediaType string
+	q         float64
+	order     int
+}
+
+//specificity ranks exact types above type/* above */*
+func (m mediaRange) specificity() int {
+	switch {
+	case m.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(m.mediaType, "/*"):
+		return 1
+	}
+	return 2
+}
+
+//matches reports whether a media type is in the range
+func (m mediaRange) matches(mediaType string) bool {
+	if m.mediaType == "*/*" || m.mediaType == mediaType {
+		return true
+	}
+	return strings.HasSuffix(m.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(m.mediaType, "*"))
+}
+
+//parseAccept returns the media ranges of an Accept header, most preferred first
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for i, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		m := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1, order: i}
+		if m.mediaType == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				q, err := strconv.ParseFloat(kv[1], 64)
+				if err == nil {
+					m.q = q
+				}
+			}
+		}
+		ranges = append(ranges, m)
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return ranges[i].specificity() > ranges[j].specificity()
+	})
+	return ranges
+}
+
+//NegotiateEncoder picks the encoder for an Accept header, false if none of the formats is acceptable
+func NegotiateEncoder(accept string) (ResponseEncoder, string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return ResponseEncoders[0], ResponseEncoders[0].MediaTypes()[0], true
+	}
+	ranges := parseAccept(accept)
+	for _, m := range ranges {
+		if m.q <= 0 {
+			continue
+		}
+		for _, encoder := range ResponseEncoders {
+			for _, mediaType := range encoder.MediaTypes() {
+				if m.matches(mediaType) && !refused(ranges, mediaType) {
+					return encoder, encoder.MediaTypes()[0], true
+				}
+			}
+		}
+	}
+	return nil, "", false
+}
+
+//refused reports whether the client sent q=0 for exactly this media type
+func refused(ranges []mediaRange, mediaType string) bool {
+	for _, m := range ranges {
+		if m.q <= 0 && m.mediaType == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+//AvailableMediaTypes lists the formats, for a 406 response
+func AvailableMediaTypes() []string {
+	var mediaTypes []string
+	for _, encoder := range ResponseEncoders {
+		mediaTypes = append(mediaTypes, encoder.MediaTypes()[0])
+	}
+	return mediaTypes
+}
+
+//cardField is one field of a CreditCard under its JSON name, every format uses these so they stay in step with the model
+type cardField struct {
+	Name  string
+	Value interface{}
+}
+
+//cardFields returns the fields of a card in declaration order
+func cardFields(card CreditCard) []cardField {
+	value := reflect.ValueOf(card)
+	var fields []cardField
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = value.Type().Field(i).Name
+		}
+		fields = append(fields, cardField{Name: name, Value: value.Field(i).Interface()})
+	}
+	return fields
+}
+
+//formatScalar writes a string or number the way the text formats show it
+func formatScalar(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+//JSONEncoder is the original format of the endpoint
+type JSONEncoder struct{}
+
+//MediaTypes of JSON
+func (JSONEncoder) MediaTypes() []string {
+	return []string{"application/json"}
+}
+
+//Encode writes the cards as a JSON array
+func (JSONEncoder) Encode(w io.Writer, creditcards []CreditCard) error {
+	return json.NewEncoder(w).Encode(creditcards)
+}
+
+//CSVEncoder writes a header row of the JSON field names then one row per card, features are joined with "; "
+type CSVEncoder struct{}
+
+//MediaTypes of CSV
+func (CSVEncoder) MediaTypes() []string {
+	return []string{"text/csv"}
+}
+
+//Encode writes the cards as CSV
+func (CSVEncoder) Encode(w io.Writer, creditcards []CreditCard) error {
+	writer := csv.NewWriter(w)
+	var header []string
+	for _, field := range cardFields(CreditCard{}) {
+		header = append(header, field.Name)
+	}
+	writer.Write(header)
+	for _, card := range creditcards {
+		var row []string
+		for _, field := range cardFields(card) {
+			if list, ok := field.Value.([]string); ok {
+				row = append(row, strings.Join(list, "; "))
+				continue
+			}
+			row = append(row, formatScalar(field.Value))
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+//XMLEncoder writes <creditcards> with a <creditcard> per card, list fields hold one element per item
+type XMLEncoder struct{}
+
+//MediaTypes of XML
+func (XMLEncoder) MediaTypes() []string {
+	return []string{"application/xml", "text/xml"}
+}
+
+//Encode writes the cards as XML
+func (XMLEncoder) Encode(w io.Writer, creditcards []CreditCard) error {
+	io.WriteString(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	start := func(name string) xml.StartElement { return xml.StartElement{Name: xml.Name{Local: name}} }
+	element := func(name, text string) {
+		encoder.EncodeToken(start(name))
+		encoder.EncodeToken(xml.CharData(text))
+		encoder.EncodeToken(start(name).End())
+	}
+
+	encoder.EncodeToken(start("creditcards"))
+	for _, card := range creditcards {
+		encoder.EncodeToken(start("creditcard"))
+		for _, field := range cardFields(card) {
+			list, ok := field.Value.([]string)
+			if !ok {
+				element(field.Name, formatScalar(field.Value))
+				continue
+			}
+			encoder.EncodeToken(start(field.Name))
+			for _, item := range list {
+				element(strings.TrimSuffix(field.Name, "s"), item)
+			}
+			encoder.EncodeToken(start(field.Name).End())
+		}
+		encoder.EncodeToken(start("creditcard").End())
+	}
+	encoder.EncodeToken(start("creditcards").End())
+	err := encoder.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+//MessagePackEncoder writes an array with a map per card keyed by the JSON field names,
+//it only needs strings, floats, string lists and nil so it is written by hand rather than pulling in a library
+type MessagePackEncoder struct{}
+
+//MediaTypes of MessagePack
+func (MessagePackEncoder) MediaTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}
+}
+
+//Encode writes the cards as MessagePack
+func (MessagePackEncoder) Encode(w io.Writer, creditcards []CreditCard) error {
+	var buf []byte
+	buf = msgpackHeader(buf, len(creditcards), 0x90, 0xdc)
+	for _, card := range creditcards {
+		fields := cardFields(card)
+		buf = msgpackHeader(buf, len(fields), 0x80, 0xde)
+		for _, field := range fields {
+			buf = msgpackString(buf, field.Name)
+			switch v := field.Value.(type) {
+			case string:
+				buf = msgpackString(buf, v)
+			case float64:
+				buf = append(buf, 0xcb)
+				buf = appendUint(buf, math.Float64bits(v), 8)
+			case []string:
+				if v == nil {
+					buf = append(buf, 0xc0)
+					continue
+				}
+				buf = msgpackHeader(buf, len(v), 0x90, 0xdc)
+				for _, item := range v {
+					buf = msgpackString(buf, item)
+				}
+			default:
+				return fmt.Errorf("unable to encode %s in MessagePack", field.Name)
+			}
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+//msgpackHeader appends an array or map header, fix is the fixarray or fixmap prefix and wide the 16 bit form,
+//the 32 bit form always follows it
+func msgpackHeader(buf []byte, n int, fix, wide byte) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint(append(buf, wide), uint64(n), 2)
+	}
+	return appendUint(append(buf, wide+1), uint64(n), 4)
+}
+
+//msgpackString appends a UTF-8 string in its shortest form
+func msgpackString(buf []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = appendUint(append(buf, 0xda), uint64(n), 2)
+	default:
+		buf = appendUint(append(buf, 0xdb), uint64(n), 4)
+	}
+	return append(buf, s...)
+}
+
+//appendUint appends the low size bytes of v big-endian
+func appendUint(buf []byte, v uint64, size int) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(buf, b[8-size:]...)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+//updateGolden rewrites the golden files from the current encoders, run `go test -run Encoders -update` and review the diff
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+//goldenCards has the characters each format has to escape and a card without features
+var goldenCards = []CreditCard{
+	{
+		Provider:  "ScoredCards",
+		Name:      "ScoredCard Builder",
+		ApplyURL:  "http://www.example.com/apply?a=1&b=2",
+		Apr:       19.4,
+		Features:  []string{"Supports ApplePay", "Interest free purchases for 1 month"},
+		CardScore: 0.212,
+	},
+	{
+		Provider:  "CSCards",
+		Name:      `SuperSaver "Plus", <Gold>`,
+		ApplyURL:  "http://www.example.com/apply",
+		Apr:       21.4,
+		Features:  []string{"Café rewards; 2% back"},
+		CardScore: 0.137,
+	},
+	{
+		Provider:  "CSCards",
+		Name:      "SuperSpender Card",
+		ApplyURL:  "http://www.example.com/apply",
+		Apr:       19.2,
+		CardScore: 0.135,
+	},
+}
+
+//TestEncodersGolden checks every format against its golden file
+func TestEncodersGolden(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Encoder ResponseEncoder
+		Golden  string
+	}{
+		{Message: "should match the JSON golden file", Encoder: JSONEncoder{}, Golden: "creditcards.json"},
+		{Message: "should match the CSV golden file", Encoder: CSVEncoder{}, Golden: "creditcards.csv"},
+		{Message: "should match the XML golden file", Encoder: XMLEncoder{}, Golden: "creditcards.xml"},
+		{Message: "should match the MessagePack golden file", Encoder: MessagePackEncoder{}, Golden: "creditcards.msgpack"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			var out bytes.Buffer
+			g.Expect(test.Encoder.Encode(&out, goldenCards)).To(gomega.Succeed())
+
+			path := filepath.Join("testdata", "golden", test.Golden)
+			if *updateGolden {
+				g.Expect(ioutil.WriteFile(path, out.Bytes(), 0644)).To(gomega.Succeed())
+			}
+			golden, err := ioutil.ReadFile(path)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(out.String()).To(gomega.Equal(string(golden)))
+		})
+	}
+}
+
+//TestMessagePackMatchesJSON decodes the MessagePack output and checks it holds what the JSON output does
+func TestMessagePackMatchesJSON(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	var packed, encoded bytes.Buffer
+	g.Expect(MessagePackEncoder{}.Encode(&packed, goldenCards)).To(gomega.Succeed())
+	g.Expect(JSONEncoder{}.Encode(&encoded, goldenCards)).To(gomega.Succeed())
+
+	var fromJSON interface{}
+	g.Expect(json.Unmarshal(encoded.Bytes(), &fromJSON)).To(gomega.Succeed())
+	fromMessagePack, rest := decodeMessagePack(packed.Bytes())
+	g.Expect(rest).To(gomega.BeEmpty())
+	g.Expect(fromMessagePack).To(gomega.Equal(fromJSON))
+
+	//long strings and lists use the wider headers
+	long := CreditCard{Name: string(bytes.Repeat([]byte("x"), 300)), Features: make([]string, 20)}
+	packed.Reset()
+	g.Expect(MessagePackEncoder{}.Encode(&packed, []CreditCard{long})).To(gomega.Succeed())
+	decoded, _ := decodeMessagePack(packed.Bytes())
+	card := decoded.([]interface{})[0].(map[string]interface{})
+	g.Expect(card["name"]).To(gomega.Equal(long.Name))
+	g.Expect(card["features"]).To(gomega.HaveLen(20))
+}
+
+//decodeMessagePack reads the subset of MessagePack the encoder writes into the types encoding/json decodes to
+func decodeMessagePack(b []byte) (interface{}, []byte) {
+	prefix := b[0]
+	b = b[1:]
+	readUint := func(size int) int {
+		var buf [8]byte
+		copy(buf[8-size:], b[:size])
+		b = b[size:]
+		return int(binary.BigEndian.Uint64(buf[:]))
+	}
+	var n int
+	switch {
+	case prefix == 0xc0:
+		return nil, b
+	case prefix == 0xcb:
+		return math.Float64frombits(uint64(readUint(8))), b
+	case prefix&0xe0 == 0xa0, prefix == 0xd9, prefix == 0xda, prefix == 0xdb:
+		switch prefix {
+		case 0xd9:
+			n = readUint(1)
+		case 0xda:
+			n = readUint(2)
+		case 0xdb:
+			n = readUint(4)
+		default:
+			n = int(prefix & 0x1f)
+		}
+		return string(b[:n]), b[n:]
+	case prefix&0xf0 == 0x90, prefix == 0xdc:
+		n = int(prefix & 0x0f)
+		if prefix == 0xdc {
+			n = readUint(2)
+		}
+		list := []interface{}{}
+		for i := 0; i < n; i++ {
+			var item interface{}
+			item, b = decodeMessagePack(b)
+			list = append(list, item)
+		}
+		return list, b
+	case prefix&0xf0 == 0x80, prefix == 0xde:
+		n = int(prefix & 0x0f)
+		if prefix == 0xde {
+			n = readUint(2)
+		}
+		m := map[string]interface{}{}
+		for i := 0; i < n; i++ {
+			var key, value interface{}
+			key, b = decodeMessagePack(b)
+			value, b = decodeMessagePack(b)
+			m[key.(string)] = value
+		}
+		return m, b
+	}
+	panic(fmt.Sprintf("unexpected MessagePack prefix %x", prefix))
+}
+
+//TestNegotiateEncoder checks which format each Accept header gets
+func TestNegotiateEncoder(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message     string
+		Accept      string
+		ContentType string
+		Acceptable  bool
+	}{
+		{Message: "should default to JSON without an Accept header", Accept: "", ContentType: "application/json", Acceptable: true},
+		{Message: "should default to JSON for anything", Accept: "*/*", ContentType: "application/json", Acceptable: true},
+		{Message: "should pick CSV", Accept: "text/csv", ContentType: "text/csv", Acceptable: true},
+		{Message: "should pick XML for text/xml", Accept: "text/xml", ContentType: "application/xml", Acceptable: true},
+		{Message: "should pick MessagePack under an alias", Accept: "application/x-msgpack", ContentType: "application/msgpack", Acceptable: true},
+		{Message: "should pick the highest quality", Accept: "application/json;q=0.5, text/csv;q=0.9", ContentType: "text/csv", Acceptable: true},
+		{Message: "should prefer an exact type over a wildcard of the same quality", Accept: "*/*, application/xml", ContentType: "application/xml", Acceptable: true},
+		{Message: "should match a type wildcard", Accept: "text/*", ContentType: "text/csv", Acceptable: true},
+		{Message: "should skip a type refused with q=0", Accept: "application/json;q=0, */*;q=0.1", ContentType: "text/csv", Acceptable: true},
+		{Message: "should fail as no format is acceptable", Accept: "application/pdf", Acceptable: false},
+		{Message: "should fail as the only match is refused", Accept: "text/csv;q=0", Acceptable: false},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			_, contentType, ok := NegotiateEncoder(test.Accept)
+			g.Expect(ok).To(gomega.Equal(test.Acceptable))
+			g.Expect(contentType).To(gomega.Equal(test.ContentType))
+		})
+	}
+}
+
+//TestHandlerAccept checks the Handler answers in the negotiated format and refuses unacceptable ones before calling providers
+func TestHandlerAccept(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message     string
+		Accept      string
+		Status      int
+		ContentType string
+		Prefix      string
+	}{
+		{Message: "should answer in JSON", Accept: "application/json", Status: http.StatusOK, ContentType: "application/json", Prefix: `[{"provider":"ScoredCards"`},
+		{Message: "should answer in CSV", Accept: "text/csv", Status: http.StatusOK, ContentType: "text/csv", Prefix: "provider,name,apply-url,apr,features,card-score\nScoredCards,"},
+		{Message: "should answer in XML", Accept: "application/xml", Status: http.StatusOK, ContentType: "application/xml", Prefix: xmlHeaderPrefix},
+		{Message: "should answer in MessagePack", Accept: "application/msgpack", Status: http.StatusOK, ContentType: "application/msgpack", Prefix: "\x93\x86"},
+		{Message: "should fail as the format is not available", Accept: "application/pdf", Status: http.StatusNotAcceptable, ContentType: "text/plain; charset=utf-8", Prefix: "not acceptable, available formats are application/json, text/csv, application/xml, application/msgpack"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			reqBody := []byte(`{"firstname": "John", "lastname": "Smith", "dob": "1991/04/18", "credit-score": 500, "employment-status": "FULL_TIME", "salary": 30000}`)
+			req := httptest.NewRequest(http.MethodPost, "/v1/creditcard", bytes.NewReader(reqBody))
+			req.Header.Set("Accept", test.Accept)
+			rr := httptest.NewRecorder()
+			Handler(DecodeConfig{})(rr, req)
+
+			g.Expect(rr.Code).To(gomega.Equal(test.Status))
+			g.Expect(rr.Header().Get("Content-Type")).To(gomega.Equal(test.ContentType))
+			g.Expect(rr.Header().Get("Vary")).To(gomega.Equal("Accept"))
+			g.Expect(rr.Body.String()).To(gomega.HavePrefix(test.Prefix))
+		})
+	}
+}
+
+//xmlHeaderPrefix is how the XML responses start
+const xmlHeaderPrefix = `<?xml version="1.0" encoding="UTF-8"?>
+<creditcards>
+  <creditcard>
+    <provider>ScoredCards</provider>`
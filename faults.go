@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//FaultConfig is the faults section of the config file, faults are refused in production
+type FaultConfig struct {
+	Enabled bool        `json:"enabled"`
+	Seed    int64       `json:"seed"`
+	Rules   []FaultRule `json:"rules"`
+}
+
+//FaultRule injects one kind of fault into a share of the requests to a provider
+type FaultRule struct {
+	//Provider is CSCards, ScoredCards or an adapter name, empty matches every provider
+	Provider string `json:"provider"`
+	//Fault is latency, reset, status, truncate, malformed or slow-drip
+	Fault string `json:"fault"`
+	//Percent of the matching requests that get the fault, 0 means all of them
+	Percent float64 `json:"percent"`
+	//DelayMillis is the latency added, or the pause between bytes for slow-drip
+	DelayMillis int `json:"delay-millis"`
+	//Status is the status code returned by a status fault, 503 by default
+	Status int `json:"status"`
+	//StartAfter lets this many matching requests through before the rule applies
+	StartAfter int `json:"start-after"`
+	//ActiveSeconds and PeriodSeconds turn the rule on for the first ActiveSeconds of every PeriodSeconds
+	ActiveSeconds int `json:"active-seconds"`
+	PeriodSeconds int `json:"period-seconds"`
+}
+
+//faultKinds are the faults a rule can inject
+var faultKinds = map[string]bool{"latency": true, "reset": true, "status": true, "truncate": true, "malformed": true, "slow-drip": true}
+
+//faultEnvironments are the only environments faults may be injected in, any other name, such as a misspelt production, is refused
+var faultEnvironments = map[string]bool{"development": true, "test": true}
+
+//Validate checks the faults are known and only injected in development or test
+func (cfg FaultConfig) Validate(environment string) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if !faultEnvironments[environment] {
+		return fmt.Errorf("fault injection can only be enabled in development or test, not %q", environment)
+	}
+	for _, rule := range cfg.Rules {
+		if !faultKinds[rule.Fault] {
+			return fmt.Errorf("unknown fault %q", rule.Fault)
+		}
+		if rule.Percent < 0 || rule.Percent > 100 {
+			return fmt.Errorf("fault %s percent must be between 0 and 100", rule.Fault)
+		}
+		if rule.ActiveSeconds > 0 && rule.PeriodSeconds < rule.ActiveSeconds {
+			return fmt.Errorf("fault %s period-seconds must be at least active-seconds", rule.Fault)
+		}
+	}
+	return nil
+}
+
+//FaultTransport wraps a provider transport and injects the configured faults
+type FaultTransport struct {
+	Rules []FaultRule
+	Next  http.RoundTripper
+
+	mu      sync.Mutex
+	random  *rand.Rand
+	matched []int
+	started time.Time
+	now     func() time.Time
+}
+
+//NewFaultTransport creates a transport injecting the faults in the config into requests sent through next
+func NewFaultTransport(cfg FaultConfig, next http.RoundTripper) *FaultTransport {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &FaultTransport{
+		Rules:   cfg.Rules,
+		Next:    next,
+		random:  rand.New(rand.NewSource(seed)),
+		matched: make([]int, len(cfg.Rules)),
+		started: time.Now(),
+		now:     time.Now,
+	}
+}
+
+//RoundTrip applies the first rule that fires for the request's provider
+func (transport *FaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := transport.Next
+	if next == nil {
+		next = ProviderBaseTransport
+	}
+
+	rule := transport.pick(providerForURL(req.URL))
+	if rule == nil {
+		return next.RoundTrip(req)
+	}
+
+	delay := time.Duration(rule.DelayMillis) * time.Millisecond
+	switch rule.Fault {
+	case "latency":
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		return next.RoundTrip(req)
+	case "reset":
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	case "status":
+		status := rule.Status
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		return faultResponse(req, status, `{"message": "injected fault"}`), nil
+	case "malformed":
+		return faultResponse(req, http.StatusOK, `[{"cardName": "Injected", "apr": `), nil
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	switch rule.Fault {
+	case "truncate":
+		resp.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body[:len(body)/2]), errorReader{io.ErrUnexpectedEOF}))
+	case "slow-drip":
+		resp.Body = ioutil.NopCloser(&dripReader{body: body, delay: delay, done: req.Context().Done()})
+	}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+//pick returns the first rule for the provider that is scheduled and wins its percentage roll
+func (transport *FaultTransport) pick(provider string) *FaultRule {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	for i := range transport.Rules {
+		rule := &transport.Rules[i]
+		if rule.Provider != "" && rule.Provider != provider {
+			continue
+		}
+		transport.matched[i]++
+		if transport.matched[i] <= rule.StartAfter {
+			continue
+		}
+		if rule.ActiveSeconds > 0 {
+			elapsed := transport.now().Sub(transport.started) % (time.Duration(rule.PeriodSeconds) * time.Second)
+			if elapsed >= time.Duration(rule.ActiveSeconds)*time.Second {
+				continue
+			}
+		}
+		if rule.Percent > 0 && transport.random.Float64()*100 >= rule.Percent {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+//providerForURL names the provider an outbound request is for
+func providerForURL(u *url.URL) string {
+	target := u.Host + u.Path
+	endpoints := map[string]string{"CSCards": CSCardsEndpoint, "ScoredCards": ScoredCardsEndpoint}
+	for _, provider := range ConfiguredProviders {
+		endpoints[provider.Config.Name] = provider.Config.Endpoint
+	}
+	for name, endpoint := range endpoints {
+		parsed, err := url.Parse(endpoint)
+		if err == nil && strings.HasPrefix(target, parsed.Host+parsed.Path) {
+			return name
+		}
+	}
+	return ""
+}
+
+func faultResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+//dripReader hands out the body one byte at a time with a pause before each byte
+type dripReader struct {
+	body  []byte
+	delay time.Duration
+	done  <-chan struct{}
+}
+
+func (r *dripReader) Read(p []byte) (int, error) {
+	if len(r.body) == 0 {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	select {
+	case <-time.After(r.delay):
+	case <-r.done:
+		return 0, fmt.Errorf("request cancelled while reading a slow response")
+	}
+	p[0] = r.body[0]
+	r.body = r.body[1:]
+	return 1, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+//TestHandlerResilience injects each fault into the ScoredCards call and checks the handler still answers cleanly
+func TestHandlerResilience(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	defer func() { ProviderTransport = nil }()
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Rule    FaultRule
+		Status  int
+		Body    string
+	}{
+		{Message: "should not fail as latency only slows the provider down", Rule: FaultRule{Fault: "latency", DelayMillis: 20}, Status: http.StatusOK},
+		{Message: "should not fail as a slow drip still delivers the whole body", Rule: FaultRule{Fault: "slow-drip", DelayMillis: 0}, Status: http.StatusOK},
+		{Message: "should fail as the connection is reset", Rule: FaultRule{Fault: "reset"}, Status: 400, Body: "unable to retrieve ScoredCards"},
+		{Message: "should fail as the provider returns a 5xx", Rule: FaultRule{Fault: "status", Status: 502}, Status: 400, Body: "unable to retrieve ScoredCards"},
+		{Message: "should fail as the body is truncated", Rule: FaultRule{Fault: "truncate"}, Status: 400, Body: "unable to retrieve ScoredCards"},
+		{Message: "should fail as the body is malformed", Rule: FaultRule{Fault: "malformed"}, Status: 400, Body: "unable to retrieve ScoredCards"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			test.Rule.Provider = "ScoredCards"
+			ProviderTransport = NewFaultTransport(FaultConfig{Enabled: true, Seed: 1, Rules: []FaultRule{test.Rule}}, nil)
+
+			reqBody := []byte(`{"firstname": "John", "lastname": "Smith", "dob": "1991/04/18", "credit-score": 500, "employment-status": "FULL_TIME", "salary": 30000}`)
+			rr := httptest.NewRecorder()
+			Handler(DecodeConfig{})(rr, httptest.NewRequest(http.MethodPost, "/v1/creditcard", bytes.NewReader(reqBody)))
+			g.Expect(rr.Code).To(gomega.Equal(test.Status))
+			if test.Body != "" {
+				g.Expect(rr.Body.String()).To(gomega.Equal(test.Body))
+			}
+		})
+	}
+}
+
+//TestFaultSchedule checks rules only fire for their provider, after StartAfter, in their window and at their rate
+func TestFaultSchedule(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+
+	statusOf := func(transport http.RoundTripper, endpoint string) int {
+		req, _ := http.NewRequest("POST", endpoint, nil)
+		resp, err := transport.RoundTrip(req)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	//degrades ScoredCards after two calls and leaves CSCards alone
+	transport := NewFaultTransport(FaultConfig{Rules: []FaultRule{{Provider: "ScoredCards", Fault: "status", StartAfter: 2}}}, nil)
+	g.Expect(statusOf(transport, CSCardsEndpoint)).To(gomega.Equal(http.StatusOK))
+	g.Expect(statusOf(transport, ScoredCardsEndpoint)).To(gomega.Equal(http.StatusOK))
+	g.Expect(statusOf(transport, ScoredCardsEndpoint)).To(gomega.Equal(http.StatusOK))
+	g.Expect(statusOf(transport, ScoredCardsEndpoint)).To(gomega.Equal(http.StatusServiceUnavailable))
+	g.Expect(statusOf(transport, CSCardsEndpoint)).To(gomega.Equal(http.StatusOK))
+
+	//only active for the first 10 seconds of every minute
+	transport = NewFaultTransport(FaultConfig{Rules: []FaultRule{{Fault: "status", ActiveSeconds: 10, PeriodSeconds: 60}}}, nil)
+	now := transport.started
+	transport.now = func() time.Time { return now }
+	g.Expect(statusOf(transport, CSCardsEndpoint)).To(gomega.Equal(http.StatusServiceUnavailable))
+	now = now.Add(30 * time.Second)
+	g.Expect(statusOf(transport, CSCardsEndpoint)).To(gomega.Equal(http.StatusOK))
+	now = now.Add(35 * time.Second)
+	g.Expect(statusOf(transport, CSCardsEndpoint)).To(gomega.Equal(http.StatusServiceUnavailable))
+
+	//roughly the configured share of requests fail
+	transport = NewFaultTransport(FaultConfig{Seed: 42, Rules: []FaultRule{{Fault: "status", Percent: 25}}}, nil)
+	failed := 0
+	for i := 0; i < 400; i++ {
+		if statusOf(transport, CSCardsEndpoint) != http.StatusOK {
+			failed++
+		}
+	}
+	g.Expect(failed).To(gomega.BeNumerically("~", 100, 30))
+}
+
+//TestFaultConfigValidate checks faults are refused outside development and test
+func TestFaultConfigValidate(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	cfg := FaultConfig{Enabled: true, Rules: []FaultRule{{Fault: "reset"}}}
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message     string
+		Environment string
+		Error       string
+	}{
+		{Message: "should pass in development", Environment: "development"},
+		{Message: "should pass in test", Environment: "test"},
+		{Message: "should fail in production", Environment: "production", Error: `fault injection can only be enabled in development or test, not "production"`},
+		{Message: "should fail as the name is only another spelling of production", Environment: "Production", Error: `fault injection can only be enabled in development or test, not "Production"`},
+		{Message: "should fail as the environment is not allowed", Environment: "staging-prod", Error: `fault injection can only be enabled in development or test, not "staging-prod"`},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			err := cfg.Validate(test.Environment)
+			if test.Error == "" {
+				g.Expect(err).NotTo(gomega.HaveOccurred())
+				return
+			}
+			g.Expect(err).To(gomega.MatchError(test.Error))
+		})
+	}
+
+	cfg.Rules[0].Fault = "fire"
+	g.Expect(cfg.Validate("test")).To(gomega.MatchError(`unknown fault "fire"`))
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/heroku/go-getting-started/client"
+)
+
+//IdempotencyKeyHeader is the header clients set to make retrying a POST safe
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+//maxIdempotencyKeyLength is the longest key accepted, enough for a UUID or a hash with a prefix
+const maxIdempotencyKeyLength = 255
+
+//IdempotencyConfig is the idempotency section of the config file
+type IdempotencyConfig struct {
+	//TTLSeconds is how long a response is kept for retries with the same key
+	TTLSeconds int `json:"ttl-seconds"`
+}
+
+//Validate checks responses are kept for some time
+func (cfg IdempotencyConfig) Validate() error {
+	if cfg.TTLSeconds < 1 {
+		return fmt.Errorf("idempotency needs a positive ttl-seconds")
+	}
+	return nil
+}
+
+//IdempotentResponse is the response to the first request with a key, a response without a Status is still being made
+type IdempotentResponse struct {
+	//Fingerprint is the hash of the request's method, path, format and body, a retry has to have the same one
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	ExpiresAt   time.Time   `json:"expires-at"`
+}
+
+//IdempotencyStore keeps the responses by key, implement it to share them between dynos or keep them across restarts
+type IdempotencyStore interface {
+	//Reserve claims the key for a new request unless it is already held. It returns the response held for the key,
+	//or nil when the key was free and is now reserved until expiresAt for the caller
+	Reserve(key string, fingerprint string, expiresAt time.Time, now time.Time) (*IdempotentResponse, error)
+	//Save stores the response of the request that reserved the key
+	Save(key string, response *IdempotentResponse) error
+	//Release frees the key of a request whose response is not kept, so a retry runs again
+	Release(key string) error
+}
+
+//MemoryIdempotencyStore keeps the responses in process memory, it is the default store
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]IdempotentResponse
+	lastSweep time.Time
+}
+
+//NewMemoryIdempotencyStore creates an empty in-memory store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{responses: map[string]IdempotentResponse{}}
+}
+
+//Reserve returns a copy of the response held for the key so callers cannot change the stored one
+func (store *MemoryIdempotencyStore) Reserve(key string, fingerprint string, expiresAt time.Time, now time.Time) (*IdempotentResponse, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	//drops expired responses once a minute so keys that are never retried do not pile up
+	if now.Sub(store.lastSweep) > time.Minute {
+		for k, response := range store.responses {
+			if !now.Before(response.ExpiresAt) {
+				delete(store.responses, k)
+			}
+		}
+		store.lastSweep = now
+	}
+
+	response, ok := store.responses[key]
+	if ok && now.Before(response.ExpiresAt) {
+		return &response, nil
+	}
+	store.responses[key] = IdempotentResponse{Fingerprint: fingerprint, ExpiresAt: expiresAt}
+	return nil, nil
+}
+
+//Save stores the response, keeping the expiry given when the key was reserved
+func (store *MemoryIdempotencyStore) Save(key string, response *IdempotentResponse) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	reserved, ok := store.responses[key]
+	if !ok {
+		return fmt.Errorf("idempotency key %s is not reserved", key)
+	}
+	saved := *response
+	saved.ExpiresAt = reserved.ExpiresAt
+	store.responses[key] = saved
+	return nil
+}
+
+//Release drops the key
+func (store *MemoryIdempotencyStore) Release(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.responses, key)
+	return nil
+}
+
+//Idempotency replays the response to the first request with an Idempotency-Key to retries of it,
+//so a client that lost the answer can retry without asking the providers or queueing a job twice
+type Idempotency struct {
+	Config IdempotencyConfig
+	Store  IdempotencyStore
+	//Decoding limits the bodies read to fingerprint them, as the handlers behind would
+	Decoding DecodeConfig
+	//TrustForwardedFor scopes the keys of anonymous clients by the last X-Forwarded-For hop, as the rate limiter does
+	TrustForwardedFor bool
+	Now               func() time.Time
+}
+
+//NewIdempotency creates the middleware backed by the in-memory store
+func NewIdempotency(cfg IdempotencyConfig, decoding DecodeConfig, trustForwardedFor bool) *Idempotency {
+	return &Idempotency{Config: cfg, Store: NewMemoryIdempotencyStore(), Decoding: decoding, TrustForwardedFor: trustForwardedFor, Now: time.Now}
+}
+
+//Handle wraps a POST handler, requests without a key go straight to it. A retry with the same body gets the stored
+//response with an Idempotent-Replayed header, one with a different body is refused with 422 and one sent while
+//the first is still running is refused with 409. Responses a retry could change, server errors and retryable
+//problems, are not kept
+func (idem *Idempotency) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeProblem(w, r, client.ProblemInvalidRequest, 400, "please enter an idempotency key of at most %d characters", maxIdempotencyKeyLength)
+			return
+		}
+
+		//reads the body to fingerprint it and hands the handler a copy
+		reqBody, err := readBody(r, idem.Decoding.maxBodyBytes(), "application/json")
+		if err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+		fingerprint := requestFingerprint(r, reqBody)
+
+		//keys are per client, or per address without authentication, so a caller who guesses another's key
+		//does not get its response
+		scoped := "ip:" + clientIP(r, idem.TrustForwardedFor) + ":" + key
+		if c := ClientFromContext(r.Context()); c != nil {
+			scoped = "client:" + c.Name + ":" + key
+		}
+
+		now := idem.Now()
+		stored, err := idem.Store.Reserve(scoped, fingerprint, now.Add(time.Duration(idem.Config.TTLSeconds)*time.Second), now)
+		if err != nil {
+			log.Printf("unable to reserve idempotency key: %v", err)
+			writeProblem(w, r, client.ProblemInternal, 500, "unable to check the idempotency key")
+			return
+		}
+		if stored != nil {
+			switch {
+			case stored.Fingerprint != fingerprint:
+				writeProblem(w, r, client.ProblemIdempotencyKeyReused, http.StatusUnprocessableEntity, "the idempotency key was already used for a different request")
+			case stored.Status == 0:
+				w.Header().Set("Retry-After", "1")
+				writeProblem(w, r, client.ProblemConflict, http.StatusConflict, "a request with this idempotency key is still running, please retry later")
+			default:
+				replayResponse(w, stored)
+			}
+			return
+		}
+
+		recorder := &idempotencyRecorder{ResponseWriter: w}
+		//releases the key of a handler that panics, it would otherwise answer 409 until the reservation expires
+		completed := false
+		defer func() {
+			if !completed {
+				idem.release(scoped)
+			}
+		}()
+		next(recorder, r)
+		completed = true
+
+		if recorder.status == 0 || recorder.retryable || recorder.status >= 500 || recorder.status == http.StatusTooManyRequests {
+			idem.release(scoped)
+			return
+		}
+		err = idem.Store.Save(scoped, &IdempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      recorder.status,
+			Header:      recorder.header,
+			Body:        recorder.body.Bytes(),
+		})
+		if err != nil {
+			log.Printf("unable to save idempotent response: %v", err)
+		}
+	}
+}
+
+//release frees a reserved key so a retry runs again
+func (idem *Idempotency) release(key string) {
+	err := idem.Store.Release(key)
+	if err != nil {
+		log.Printf("unable to release idempotency key: %v", err)
+	}
+}
+
+//requestFingerprint is the hash of the method, path, response format and body, so a key reused on another route,
+//or by a retry asking for another format than the stored response is in, is also caught
+func requestFingerprint(r *http.Request, body []byte) string {
+	//the negotiated format rather than the header, so Accept headers picking the same format match
+	format := r.Header.Get("Accept")
+	if _, contentType, ok := NegotiateEncoder(format); ok {
+		format = contentType
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n%s\n", r.Method, r.URL.Path, format)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+//replayResponse writes a stored response, the headers already set by the middleware before, such as the
+//rate limit, are the current ones and are not replaced
+func replayResponse(w http.ResponseWriter, stored *IdempotentResponse) {
+	for name, values := range stored.Header {
+		if _, ok := w.Header()[name]; !ok {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+//idempotencyRecorder passes the response on to the client while keeping a copy to store
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+	//retryable is set by WriteProblem for problems another attempt may not have
+	retryable bool
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = http.Header{}
+		for name, values := range rec.ResponseWriter.Header() {
+			rec.header[name] = append([]string{}, values...)
+		}
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(body []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(body)
+	return rec.ResponseWriter.Write(body)
+}
+
+//retryableProblem reports whether another attempt may get a different answer, matching the problems the Go client retries
+func retryableProblem(problemType string) bool {
+	switch problemType {
+	case client.ProblemProviderUnavailable, client.ProblemRateLimited, client.ProblemTimeout, client.ProblemUnavailable, client.ProblemInternal:
+		return true
+	}
+	return false
+}
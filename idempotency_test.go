@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heroku/go-getting-started/client"
+	"github.com/onsi/gomega"
+)
+
+//TestIdempotency checks retries with the same key are replayed and misused keys are refused
+func TestIdempotency(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	idem := NewIdempotency(IdempotencyConfig{TTLSeconds: 60}, DecodeConfig{}, false)
+	now := time.Date(2019, 11, 17, 12, 0, 0, 0, time.UTC)
+	idem.Now = func() time.Time { return now }
+
+	//answers with how many times it ran, or a provider failure for the body "down"
+	calls := 0
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := idem.Handle(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		calls++
+		switch string(body) {
+		case "down":
+			writeProblem(w, r, client.ProblemProviderUnavailable, 400, "unable to retrieve CSCards")
+			return
+		case "slow":
+			entered <- struct{}{}
+			<-release
+		case "panic":
+			panic("handler failed")
+		}
+		w.Header().Set("Location", "/v1/jobs/job")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "%s %d", body, calls)
+	})
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message    string
+		Key        string
+		Client     string
+		RemoteAddr string
+		Accept     string
+		Body       string
+		Later      time.Duration
+		Status     int
+		Response   string
+		Replayed   string
+	}{
+		{Message: "should run the request without a key", Key: "", Body: "john", Status: 202, Response: "john 1"},
+		{Message: "should run the request again without a key", Key: "", Body: "john", Status: 202, Response: "john 2"},
+		{Message: "should run the first request with a key", Key: "a", Body: "john", Status: 202, Response: "john 3"},
+		{Message: "should replay the response to a retry", Key: "a", Body: "john", Status: 202, Response: "john 3", Replayed: "true"},
+		{Message: "should refuse the key reused with another body", Key: "a", Body: "jane", Status: 422, Response: "the idempotency key was already used for a different request"},
+		{Message: "should replay to a retry asking for the same format", Key: "a", Accept: "application/json", Body: "john", Status: 202, Response: "john 3", Replayed: "true"},
+		{Message: "should refuse the key reused for another format", Key: "a", Accept: "text/csv", Body: "john", Status: 422, Response: "the idempotency key was already used for a different request"},
+		{Message: "should run the request as another client's keys are its own", Key: "a", Client: "partner", Body: "john", Status: 202, Response: "john 4"},
+		{Message: "should run the request as another address's keys are its own", Key: "a", RemoteAddr: "10.0.0.2:1234", Body: "john", Status: 202, Response: "john 5"},
+		{Message: "should run the request again once the response has expired", Key: "a", Body: "john", Later: time.Minute, Status: 202, Response: "john 6"},
+		{Message: "should answer a provider failure", Key: "b", Body: "down", Status: 400, Response: "unable to retrieve CSCards"},
+		{Message: "should retry the provider failure as it is not kept", Key: "b", Body: "down", Status: 400, Response: "unable to retrieve CSCards"},
+		{Message: "should refuse a key that is too long", Key: strings.Repeat("k", 256), Body: "john", Status: 400, Response: "please enter an idempotency key of at most 255 characters"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			now = now.Add(test.Later)
+			req := httptest.NewRequest(http.MethodPost, "/v1/jobs/creditcard", strings.NewReader(test.Body))
+			req.Header.Set(IdempotencyKeyHeader, test.Key)
+			if test.Client != "" {
+				req = req.WithContext(WithClient(req.Context(), &Client{Name: test.Client}))
+			}
+			if test.Accept != "" {
+				req.Header.Set("Accept", test.Accept)
+			}
+			if test.RemoteAddr != "" {
+				req.RemoteAddr = test.RemoteAddr
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			g.Expect(rr.Code).To(gomega.Equal(test.Status))
+			g.Expect(rr.Body.String()).To(gomega.Equal(test.Response))
+			g.Expect(rr.Header().Get("Idempotent-Replayed")).To(gomega.Equal(test.Replayed))
+			if test.Replayed != "" {
+				g.Expect(rr.Header().Get("Location")).To(gomega.Equal("/v1/jobs/job"))
+			}
+		})
+	}
+	g.Expect(calls).To(gomega.Equal(8))
+
+	//a retry sent while the first request is still running is told to wait
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/v1/jobs/creditcard", strings.NewReader("slow"))
+		req.Header.Set(IdempotencyKeyHeader, "c")
+		handler(first, req)
+		close(done)
+	}()
+	<-entered
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs/creditcard", strings.NewReader("slow"))
+	req.Header.Set(IdempotencyKeyHeader, "c")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusConflict))
+	g.Expect(rr.Header().Get("Retry-After")).To(gomega.Equal("1"))
+	close(release)
+	<-done
+	g.Expect(first.Code).To(gomega.Equal(http.StatusAccepted))
+
+	//a handler that panics does not leave its key held, the retry runs again instead of getting a 409
+	for i := 0; i < 2; i++ {
+		func() {
+			defer func() {
+				g.Expect(recover()).To(gomega.Equal("handler failed"))
+			}()
+			req := httptest.NewRequest(http.MethodPost, "/v1/jobs/creditcard", strings.NewReader("panic"))
+			req.Header.Set(IdempotencyKeyHeader, "d")
+			handler(httptest.NewRecorder(), req)
+		}()
+	}
+	stored, err := idem.Store.Reserve("ip:192.0.2.1:d", "", now.Add(time.Minute), now)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(stored).To(gomega.BeNil())
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/heroku/go-getting-started/client"
+)
+
+//JobsConfig is the jobs section of the config file
+type JobsConfig struct {
+	//Workers is how many jobs run at once, QueueSize is how many may wait for a worker before new jobs are refused
+	Workers   int `json:"workers"`
+	QueueSize int `json:"queue-size"`
+	//TTLSeconds is how long a finished job and its result are kept
+	TTLSeconds int `json:"ttl-seconds"`
+	//WebhookSecret signs the results posted to callback URLs, jobs with a callback are refused when it is empty
+	WebhookSecret string `json:"webhook-secret"`
+	//WebhookAttempts is how many times a callback is tried before it is given up on
+	WebhookAttempts int `json:"webhook-attempts"`
+	//CallbackHosts are the hosts callbacks may be posted to, callbacks to any other host are refused
+	CallbackHosts []string `json:"callback-hosts"`
+}
+
+//Validate checks the worker pool and expiry are usable
+func (cfg JobsConfig) Validate() error {
+	if cfg.Workers < 1 || cfg.QueueSize < 1 {
+		return fmt.Errorf("jobs needs a positive workers and queue-size")
+	}
+	if cfg.TTLSeconds < 1 {
+		return fmt.Errorf("jobs needs a positive ttl-seconds")
+	}
+	if cfg.WebhookAttempts < 1 {
+		return fmt.Errorf("jobs needs a positive webhook-attempts")
+	}
+	return nil
+}
+
+//Job is a recommendation run by the worker pool, only the Client that submitted it can read it
+type Job struct {
+	client.Job
+	Client      string `json:"client,omitempty"`
+	CallbackURL string `json:"callback-url,omitempty"`
+}
+
+//JobStore keeps jobs and their results, implement it to keep them in a database that outlives the dyno
+type JobStore interface {
+	Save(job *Job) error
+	//Get returns nil when there is no job with the id or it has expired
+	Get(id string, now time.Time) (*Job, error)
+	//DeleteExpired drops the jobs that have expired by now and returns how many there were
+	DeleteExpired(now time.Time) (int, error)
+}
+
+//MemoryJobStore keeps jobs in process memory, it is the default store
+type MemoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+//NewMemoryJobStore creates an empty in-memory store
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{jobs: map[string]Job{}}
+}
+
+//Save stores a copy of the job, replacing the job with the same id
+func (store *MemoryJobStore) Save(job *Job) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.jobs[job.ID] = *job
+	return nil
+}
+
+//Get returns a copy of the job so callers cannot change the stored one
+func (store *MemoryJobStore) Get(id string, now time.Time) (*Job, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	job, ok := store.jobs[id]
+	if !ok || jobExpired(&job, now) {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+//DeleteExpired drops the expired jobs
+func (store *MemoryJobStore) DeleteExpired(now time.Time) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	deleted := 0
+	for id, job := range store.jobs {
+		if jobExpired(&job, now) {
+			delete(store.jobs, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func jobExpired(job *Job, now time.Time) bool {
+	return job.ExpiresAt != nil && !now.Before(*job.ExpiresAt)
+}
+
+//jobTask is a queued job with its applicant, the applicant is only held in memory until the job has run
+type jobTask struct {
+	id       string
+	userInfo UserInfo
+}
+
+//JobRunner queues recommendation jobs for a pool of workers and posts the results to their callback URLs
+type JobRunner struct {
+	Config JobsConfig
+	Store  JobStore
+	//Decoding is how submitted applicants are read
+	Decoding DecodeConfig
+	//HTTPClient posts the callbacks
+	HTTPClient *http.Client
+	queue      chan jobTask
+	done       chan struct{}
+	now        func() time.Time
+	//webhookBackoff is the wait before the second callback attempt, it doubles after each failed attempt
+	webhookBackoff time.Duration
+}
+
+//NewJobRunner creates a runner, Start has to be called before jobs are run
+func NewJobRunner(cfg JobsConfig, store JobStore) *JobRunner {
+	return &JobRunner{
+		Config:         cfg,
+		Store:          store,
+		HTTPClient:     newWebhookClient(),
+		queue:          make(chan jobTask, cfg.QueueSize),
+		done:           make(chan struct{}),
+		now:            time.Now,
+		webhookBackoff: time.Second,
+	}
+}
+
+//Start runs the workers and drops expired jobs once a minute, until Stop is called
+func (runner *JobRunner) Start() {
+	for i := 0; i < runner.Config.Workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-runner.done:
+					return
+				case task := <-runner.queue:
+					runner.run(task)
+				}
+			}
+		}()
+	}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runner.done:
+				return
+			case <-ticker.C:
+				deleted, err := runner.Store.DeleteExpired(runner.now())
+				if err != nil {
+					log.Printf("unable to delete expired jobs: %v", err)
+				} else if deleted > 0 {
+					log.Printf("deleted %d expired jobs", deleted)
+				}
+			}
+		}
+	}()
+}
+
+//Stop stops the workers once they finish the job they are running, queued jobs are not run
+func (runner *JobRunner) Stop() {
+	close(runner.done)
+}
+
+//Submit queues a recommendation for the applicant in the body and answers 202 with the queued job,
+//an X-Callback-URL header asks for the signed result to be posted there once the job has finished
+func (runner *JobRunner) Submit(w http.ResponseWriter, r *http.Request) {
+	newUserInfo, err := DecodeApplicant(r, runner.Decoding)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+
+	callbackURL := r.Header.Get("X-Callback-URL")
+	if callbackURL != "" {
+		if runner.Config.WebhookSecret == "" {
+			writeProblem(w, r, client.ProblemInvalidRequest, 400, "callbacks are not enabled on this server")
+			return
+		}
+		parsed, err := url.Parse(callbackURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			writeProblem(w, r, client.ProblemInvalidRequest, 400, "please enter the callback as an absolute http or https url")
+			return
+		}
+		if !runner.callbackHostAllowed(parsed.Hostname()) {
+			writeProblem(w, r, client.ProblemInvalidRequest, 400, "callbacks to %s are not allowed on this server", parsed.Hostname())
+			return
+		}
+	}
+
+	job := &Job{
+		Job:         client.Job{ID: newJobID(), Status: client.JobQueued, CreatedAt: runner.now().UTC()},
+		CallbackURL: callbackURL,
+	}
+	if c := ClientFromContext(r.Context()); c != nil {
+		job.Client = c.Name
+	}
+	err = runner.Store.Save(job)
+	if err != nil {
+		log.Printf("unable to save job %s: %v", job.ID, err)
+		writeProblem(w, r, client.ProblemInternal, 500, "unable to queue the job")
+		return
+	}
+
+	select {
+	case runner.queue <- jobTask{id: job.ID, userInfo: newUserInfo}:
+	default:
+		//marks the refused job failed so it expires instead of staying queued
+		runner.finish(job, nil, client.NewProblem(client.ProblemUnavailable, http.StatusServiceUnavailable, "the job queue is full"))
+		w.Header().Set("Retry-After", "1")
+		writeProblem(w, r, client.ProblemUnavailable, http.StatusServiceUnavailable, "the job queue is full, please retry later")
+		return
+	}
+
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJob(w, http.StatusAccepted, job)
+}
+
+//Status answers with the job, jobs that have expired or belong to another client are not found
+func (runner *JobRunner) Status(w http.ResponseWriter, r *http.Request) {
+	job, err := runner.Store.Get(mux.Vars(r)["id"], runner.now())
+	if err != nil {
+		log.Printf("unable to read job: %v", err)
+		writeProblem(w, r, client.ProblemInternal, 500, "unable to read the job")
+		return
+	}
+	caller := ""
+	if c := ClientFromContext(r.Context()); c != nil {
+		caller = c.Name
+	}
+	if job == nil || job.Client != caller {
+		writeProblem(w, r, client.ProblemNotFound, 404, "job not found")
+		return
+	}
+	writeJob(w, http.StatusOK, job)
+}
+
+//writeJob answers with the client's view of the job
+func writeJob(w http.ResponseWriter, status int, job *Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(job.Job)
+}
+
+//run recommends cards for a queued job and delivers the result
+func (runner *JobRunner) run(task jobTask) {
+	job, err := runner.Store.Get(task.id, runner.now())
+	if err != nil || job == nil {
+		log.Printf("unable to run job %s: %v", task.id, err)
+		return
+	}
+	job.Status = client.JobRunning
+	err = runner.Store.Save(job)
+	if err != nil {
+		log.Printf("unable to save job %s: %v", job.ID, err)
+	}
+
+	//a job outlives the request that queued it so it is not cancelled with it
+	cards, err := Recommend(context.Background(), task.userInfo, job.ID)
+	if err != nil {
+		problem := client.NewProblem(client.ProblemProviderUnavailable, 400, err.Error())
+		if recommendErr, ok := err.(*RecommendError); ok {
+			problem.Provider = recommendErr.Provider
+		}
+		runner.finish(job, nil, problem)
+	} else {
+		if cards == nil {
+			cards = []CreditCard{}
+		}
+		runner.finish(job, cards, nil)
+	}
+
+	//delivers apart from the worker, so a slow or dead callback does not hold it from the next job
+	if job.CallbackURL != "" {
+		go runner.deliver(job)
+	}
+}
+
+//finish records the outcome of a job and when it expires
+func (runner *JobRunner) finish(job *Job, cards []CreditCard, problem *client.Problem) {
+	completed := runner.now().UTC()
+	expires := completed.Add(time.Duration(runner.Config.TTLSeconds) * time.Second)
+	job.CompletedAt, job.ExpiresAt = &completed, &expires
+	job.Cards, job.Problem = cards, problem
+	job.Status = client.JobSucceeded
+	if problem != nil {
+		job.Status = client.JobFailed
+	}
+	err := runner.Store.Save(job)
+	if err != nil {
+		log.Printf("unable to save job %s: %v", job.ID, err)
+	}
+}
+
+//deliver posts the finished job to its callback URL, signed with the webhook secret, retrying until it is accepted.
+//Stop cancels the attempt being made and the wait for the next one
+func (runner *JobRunner) deliver(job *Job) {
+	body, err := json.Marshal(job.Job)
+	if err != nil {
+		log.Printf("unable to encode job %s: %v", job.ID, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-runner.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	wait := runner.webhookBackoff
+	for attempt := 1; ; attempt++ {
+		timestamp := strconv.FormatInt(runner.now().Unix(), 10)
+		req, err := http.NewRequest(http.MethodPost, job.CallbackURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("unable to deliver job %s: %v", job.ID, err)
+			return
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Signature-Timestamp", timestamp)
+		req.Header.Set("X-Signature", SignWebhook(runner.Config.WebhookSecret, timestamp, body))
+
+		resp, err := runner.HTTPClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("callback answered with status %d", resp.StatusCode)
+		}
+		if attempt >= runner.Config.WebhookAttempts || ctx.Err() != nil {
+			log.Printf("gave up delivering job %s after %d attempts: %v", job.ID, attempt, err)
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Printf("gave up delivering job %s as the runner stopped", job.ID)
+			return
+		case <-timer.C:
+		}
+		wait *= 2
+	}
+}
+
+//callbackHostAllowed reports whether the host is one of the configured callback hosts
+func (runner *JobRunner) callbackHostAllowed(host string) bool {
+	for _, allowed := range runner.Config.CallbackHosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+//privateNetworks are the ranges that are not reachable from the internet, besides loopback and link-local
+var privateNetworks = parseNetworks("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+//publicIP reports whether the address is reachable from the internet, so it is not the dyno itself,
+//the cloud metadata service or a private network
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+//refusePrivateAddress is the dialer's check of the address a callback host resolved to, it runs after the DNS
+//lookup so a host that resolves to a private address is refused too
+func refusePrivateAddress(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return fmt.Errorf("refusing to post a callback to %s, it is not a public address", host)
+	}
+	return nil
+}
+
+//newWebhookClient makes the client that posts callbacks, it only connects to public addresses and does not
+//follow redirects, which could lead anywhere
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: refusePrivateAddress}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+//SignWebhook is the X-Signature of a callback body, receivers recompute it with the shared secret
+//and should refuse timestamps too far from their clock so old callbacks cannot be replayed
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//newJobID makes a random job id that cannot be guessed from other jobs
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/heroku/go-getting-started/client"
+	"github.com/onsi/gomega"
+)
+
+//jobApplicant is the applicant submitted as a job
+const jobApplicant = `{"firstname": "John", "lastname": "Smith", "dob": "1991/04/18", "credit-score": 500, "employment-status": "FULL_TIME", "salary": 30000}`
+
+//TestJobRunner checks a job is queued, run, delivered to its callback signed, and expires
+func TestJobRunner(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+
+	//the callback fails once so the delivery is retried
+	delivered := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	attempts := 0
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		delivered <- r
+		bodies <- body
+	}))
+	defer callback.Close()
+
+	runner := NewJobRunner(JobsConfig{Workers: 1, QueueSize: 1, TTLSeconds: 60, WebhookSecret: "shh", WebhookAttempts: 2, CallbackHosts: []string{"127.0.0.1"}}, NewMemoryJobStore())
+	runner.webhookBackoff = time.Millisecond
+	//the callback server is on loopback, which the webhook client refuses
+	runner.HTTPClient = callback.Client()
+	runner.Start()
+	defer runner.Stop()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/jobs/creditcard", strings.NewReader(jobApplicant))
+	req.Header.Set("X-Callback-URL", callback.URL)
+	req = req.WithContext(WithClient(req.Context(), &Client{Name: "billing"}))
+	rr := httptest.NewRecorder()
+	runner.Submit(rr, req)
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusAccepted))
+	var queued client.Job
+	g.Expect(json.Unmarshal(rr.Body.Bytes(), &queued)).To(gomega.Succeed())
+	g.Expect(queued.Status).To(gomega.Equal(client.JobQueued))
+	g.Expect(rr.Header().Get("Location")).To(gomega.Equal("/v1/jobs/" + queued.ID))
+
+	//the callback gets the finished job signed with the webhook secret
+	var callbackReq *http.Request
+	g.Eventually(delivered, 5*time.Second).Should(gomega.Receive(&callbackReq))
+	body := <-bodies
+	g.Expect(callbackReq.Header.Get("X-Signature")).To(gomega.Equal(SignWebhook("shh", callbackReq.Header.Get("X-Signature-Timestamp"), body)))
+	var finished client.Job
+	g.Expect(json.Unmarshal(body, &finished)).To(gomega.Succeed())
+	g.Expect(finished.ID).To(gomega.Equal(queued.ID))
+	g.Expect(finished.Status).To(gomega.Equal(client.JobSucceeded))
+	g.Expect(finished.Cards).To(gomega.HaveLen(3))
+	g.Expect(finished.ExpiresAt).NotTo(gomega.BeNil())
+	g.Expect(attempts).To(gomega.Equal(2))
+
+	status := func(id, caller string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/jobs/"+id, nil)
+		req = mux.SetURLVars(req.WithContext(WithClient(req.Context(), &Client{Name: caller})), map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+		runner.Status(rr, req)
+		return rr
+	}
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		ID      string
+		Caller  string
+		Now     time.Time
+		Code    int
+	}{
+		{Message: "should answer with the finished job", ID: queued.ID, Caller: "billing", Now: time.Now(), Code: 200},
+		{Message: "should not find another client's job", ID: queued.ID, Caller: "marketing", Now: time.Now(), Code: 404},
+		{Message: "should not find an unknown job", ID: "nope", Caller: "billing", Now: time.Now(), Code: 404},
+		{Message: "should not find an expired job", ID: queued.ID, Caller: "billing", Now: time.Now().Add(time.Minute), Code: 404},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			runner.now = func() time.Time { return test.Now }
+			rr := status(test.ID, test.Caller)
+			g.Expect(rr.Code).To(gomega.Equal(test.Code))
+			if test.Code == 200 {
+				var job client.Job
+				g.Expect(json.Unmarshal(rr.Body.Bytes(), &job)).To(gomega.Succeed())
+				g.Expect(job).To(gomega.Equal(finished))
+			}
+		})
+	}
+
+	deleted, err := runner.Store.DeleteExpired(time.Now().Add(time.Minute))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(deleted).To(gomega.Equal(1))
+}
+
+//TestJobRunnerSubmit checks jobs that cannot be run are refused
+func TestJobRunnerSubmit(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message  string
+		Secret   string
+		Hosts    []string
+		Callback string
+		Queued   int
+		Code     int
+		Body     string
+	}{
+		{Message: "should queue the job", Code: 202},
+		{Message: "should refuse a callback without a webhook secret", Callback: "https://example.com/hook", Code: 400, Body: "callbacks are not enabled on this server"},
+		{Message: "should refuse a relative callback", Secret: "shh", Callback: "/hook", Code: 400, Body: "please enter the callback as an absolute http or https url"},
+		{Message: "should refuse a callback that is not http", Secret: "shh", Callback: "file:///etc/passwd", Code: 400, Body: "please enter the callback as an absolute http or https url"},
+		{Message: "should queue the job with a callback to an allowed host", Secret: "shh", Hosts: []string{"hooks.example.com"}, Callback: "https://HOOKS.example.com/hook", Code: 202},
+		{Message: "should refuse a callback to a host that is not allowed", Secret: "shh", Hosts: []string{"hooks.example.com"}, Callback: "http://169.254.169.254/latest/meta-data", Code: 400,
+			Body: "callbacks to 169.254.169.254 are not allowed on this server"},
+		{Message: "should refuse a callback as no host is allowed", Secret: "shh", Callback: "https://example.com/hook", Code: 400, Body: "callbacks to example.com are not allowed on this server"},
+		{Message: "should refuse the job as the queue is full", Queued: 1, Code: 503, Body: "the job queue is full, please retry later"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			//the runner is not started so queued jobs stay queued
+			runner := NewJobRunner(JobsConfig{Workers: 1, QueueSize: 1, TTLSeconds: 60, WebhookSecret: test.Secret, WebhookAttempts: 1, CallbackHosts: test.Hosts}, NewMemoryJobStore())
+			for i := 0; i < test.Queued; i++ {
+				runner.queue <- jobTask{}
+			}
+			req := httptest.NewRequest(http.MethodPost, "/v1/jobs/creditcard", strings.NewReader(jobApplicant))
+			req.Header.Set("X-Callback-URL", test.Callback)
+			rr := httptest.NewRecorder()
+			runner.Submit(rr, req)
+
+			g.Expect(rr.Code).To(gomega.Equal(test.Code))
+			if test.Body != "" {
+				g.Expect(rr.Body.String()).To(gomega.Equal(test.Body))
+			}
+		})
+	}
+}
+
+//TestJobRunnerSlowCallback checks a callback that does not answer neither holds the worker nor outlives Stop
+func TestJobRunnerSlowCallback(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	release := make(chan struct{})
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer callback.Close()
+	defer close(release)
+
+	runner := NewJobRunner(JobsConfig{Workers: 1, QueueSize: 2, TTLSeconds: 60, WebhookSecret: "shh", WebhookAttempts: 3, CallbackHosts: []string{"127.0.0.1"}}, NewMemoryJobStore())
+	runner.HTTPClient = callback.Client()
+	runner.webhookBackoff = time.Hour
+	runner.Start()
+
+	//both jobs finish on the one worker while the first callback is still waiting
+	var ids []string
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/jobs/creditcard", strings.NewReader(jobApplicant))
+		req.Header.Set("X-Callback-URL", callback.URL)
+		rr := httptest.NewRecorder()
+		runner.Submit(rr, req)
+		g.Expect(rr.Code).To(gomega.Equal(http.StatusAccepted))
+		var queued client.Job
+		g.Expect(json.Unmarshal(rr.Body.Bytes(), &queued)).To(gomega.Succeed())
+		ids = append(ids, queued.ID)
+	}
+	for _, id := range ids {
+		g.Eventually(func() string {
+			job, _ := runner.Store.Get(id, time.Now())
+			return job.Status
+		}, 5*time.Second).Should(gomega.Equal(client.JobSucceeded))
+	}
+
+	//stopping the runner ends a delivery waiting on the callback
+	delivered := make(chan struct{})
+	go func() {
+		job, _ := runner.Store.Get(ids[0], time.Now())
+		runner.deliver(job)
+		close(delivered)
+	}()
+	runner.Stop()
+	g.Eventually(delivered, time.Second).Should(gomega.BeClosed())
+}
+
+//TestWebhookClient checks callbacks are only posted to public addresses, whatever the host resolves to
+func TestWebhookClient(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		IP      string
+		Public  bool
+	}{
+		{Message: "should allow a public address", IP: "93.184.216.34", Public: true},
+		{Message: "should allow a public IPv6 address", IP: "2606:2800:220:1:248:1893:25c8:1946", Public: true},
+		{Message: "should refuse loopback", IP: "127.0.0.1"},
+		{Message: "should refuse IPv6 loopback", IP: "::1"},
+		{Message: "should refuse the metadata service", IP: "169.254.169.254"},
+		{Message: "should refuse a private network", IP: "10.1.2.3"},
+		{Message: "should refuse another private network", IP: "172.20.0.1"},
+		{Message: "should refuse a home network", IP: "192.168.1.1"},
+		{Message: "should refuse a unique local IPv6 address", IP: "fd00::1"},
+		{Message: "should refuse the unspecified address", IP: "0.0.0.0"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			g.Expect(publicIP(net.ParseIP(test.IP))).To(gomega.Equal(test.Public))
+		})
+	}
+
+	//the dialer refuses a server on loopback
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, err := newWebhookClient().Post(server.URL, "application/json", strings.NewReader("{}"))
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(err.Error()).To(gomega.ContainSubstring("refusing to post a callback to 127.0.0.1, it is not a public address"))
+}
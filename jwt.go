@@ -0,0 +1,340 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/heroku/go-getting-started/client"
+)
+
+//JWTConfig is the jwt section of the config file
+type JWTConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Audience string `json:"audience"`
+	//HMACSecrets are the HS256 secrets by key id
+	HMACSecrets map[string]string `json:"hmac-secrets"`
+	//RSAPublicKeyFiles are PEM files holding the RS256 public keys by key id
+	RSAPublicKeyFiles map[string]string `json:"rsa-public-key-files"`
+	//JWKSFile is a local JSON Web Key Set with RSA and oct keys
+	JWKSFile string `json:"jwks-file"`
+	//RouteScopes are the scopes a token needs for each route template, a token granted "admin:*" has every admin scope
+	RouteScopes map[string][]string `json:"route-scopes"`
+	//DefaultTier is the rate-limit tier of tokens without a tier claim
+	DefaultTier   string `json:"default-tier"`
+	LeewaySeconds int    `json:"leeway-seconds"`
+}
+
+//Validate checks tokens can be verified against at least one key
+func (cfg JWTConfig) Validate() error {
+	if cfg.Enabled && len(cfg.HMACSecrets) == 0 && len(cfg.RSAPublicKeyFiles) == 0 && cfg.JWKSFile == "" {
+		return fmt.Errorf("jwt is enabled but no hmac-secrets, rsa-public-key-files or jwks-file are set")
+	}
+	return nil
+}
+
+//JWTClaims are the registered and custom claims the service reads from a token
+type JWTClaims struct {
+	Subject   string      `json:"sub"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *int64      `json:"exp"`
+	NotBefore *int64      `json:"nbf"`
+	Scope     string      `json:"scope"`
+	Tier      string      `json:"tier"`
+}
+
+//jwtAudience accepts the aud claim as either a string or an array of strings
+type jwtAudience []string
+
+func (aud *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = jwtAudience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("aud claim must be a string or an array of strings")
+	}
+	*aud = many
+	return nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtKey struct {
+	alg    string
+	secret []byte
+	public *rsa.PublicKey
+}
+
+//JWTVerifier validates HS256 and RS256 tokens against the configured keys
+type JWTVerifier struct {
+	config JWTConfig
+	keys   map[string]jwtKey
+	now    func() time.Time
+}
+
+//NewJWTVerifier loads the HMAC secrets, PEM files and JWKS file named in the config
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	verifier := &JWTVerifier{config: cfg, keys: map[string]jwtKey{}, now: time.Now}
+
+	for kid, secret := range cfg.HMACSecrets {
+		verifier.keys[kid] = jwtKey{alg: "HS256", secret: []byte(secret)}
+	}
+
+	for kid, path := range cfg.RSAPublicKeyFiles {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read rsa public key file %s", path)
+		}
+		block, _ := pem.Decode(body)
+		if block == nil {
+			return nil, fmt.Errorf("rsa public key file %s is not PEM encoded", path)
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse rsa public key file %s: %v", path, err)
+		}
+		public, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key file %s is not an rsa key", path)
+		}
+		verifier.keys[kid] = jwtKey{alg: "RS256", public: public}
+	}
+
+	if cfg.JWKSFile != "" {
+		err := verifier.loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return verifier, nil
+}
+
+//loadJWKS adds the RSA and oct keys of a JSON Web Key Set file
+func (verifier *JWTVerifier) loadJWKS(path string) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read jwks file %s", path)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	err = json.Unmarshal(body, &set)
+	if err != nil {
+		return fmt.Errorf("unable to parse jwks file %s: %v", path, err)
+	}
+
+	for _, key := range set.Keys {
+		switch key.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil {
+				return fmt.Errorf("jwks key %q has an invalid modulus or exponent", key.Kid)
+			}
+			public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			verifier.keys[key.Kid] = jwtKey{alg: "RS256", public: public}
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil {
+				return fmt.Errorf("jwks key %q has an invalid secret", key.Kid)
+			}
+			verifier.keys[key.Kid] = jwtKey{alg: "HS256", secret: secret}
+		default:
+			return fmt.Errorf("jwks key %q has unsupported type %q", key.Kid, key.Kty)
+		}
+	}
+	return nil
+}
+
+//Verify checks the token signature, expiry, not-before and audience and returns its claims
+func (verifier *JWTVerifier) Verify(token string) (*JWTClaims, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, "", fmt.Errorf("token is not a signed jwt")
+	}
+
+	var header jwtHeader
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, "", fmt.Errorf("token header is invalid")
+	}
+	if header.Alg != "HS256" && header.Alg != "RS256" {
+		return nil, "", fmt.Errorf("token algorithm %q is not accepted", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, "", fmt.Errorf("token signature is invalid")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	//the algorithm must match the key, so an RSA public key can never be used as an HMAC secret
+	verified := false
+	for kid, key := range verifier.keys {
+		if key.alg != header.Alg || (header.Kid != "" && kid != header.Kid) {
+			continue
+		}
+		if key.verify(signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, "", fmt.Errorf("token signature does not match any key")
+	}
+
+	var claims JWTClaims
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, "", fmt.Errorf("token claims are invalid")
+	}
+
+	now := verifier.now().Unix()
+	leeway := int64(verifier.config.LeewaySeconds)
+	if claims.ExpiresAt == nil || now > *claims.ExpiresAt+leeway {
+		return nil, "", fmt.Errorf("token has expired")
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore-leeway {
+		return nil, "", fmt.Errorf("token is not valid yet")
+	}
+	if verifier.config.Audience != "" && !claims.Audience.contains(verifier.config.Audience) {
+		return nil, "", fmt.Errorf("token is not meant for this audience")
+	}
+	if claims.Subject == "" {
+		return nil, "", fmt.Errorf("token has no subject")
+	}
+	return &claims, header.Kid, nil
+}
+
+func (key jwtKey) verify(signed, signature []byte) bool {
+	switch key.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS256":
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key.public, crypto.SHA256, sum[:], signature) == nil
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	body, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (aud jwtAudience) contains(audience string) bool {
+	for _, a := range aud {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+//HasScope reports whether the token grants the required scope, a granted scope ending in "*" covers every scope
+//it prefixes. A required wildcard is not widened, a route needing "admin:*" needs a token granted "admin:*"
+func (claims *JWTClaims) HasScope(required string) bool {
+	for _, granted := range strings.Fields(claims.Scope) {
+		if scopeMatches(granted, required) {
+			return true
+		}
+	}
+	return false
+}
+
+func scopeMatches(pattern, scope string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(scope, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == scope
+}
+
+//JWTAuth is the middleware that authenticates clients by an Authorization bearer token
+type JWTAuth struct {
+	Config   JWTConfig
+	Verifier *JWTVerifier
+	//Fallback lets requests without a bearer token through to the next authenticator in the chain
+	Fallback bool
+}
+
+//Middleware verifies the bearer token, enforces the route scopes and attaches the client to the context
+func (auth *JWTAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.Config.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			if auth.Fallback {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			writeProblem(w, r, client.ProblemUnauthorized, http.StatusUnauthorized, "please provide a bearer token")
+			return
+		}
+
+		claims, kid, err := auth.Verifier.Verify(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeProblem(w, r, client.ProblemUnauthorized, http.StatusUnauthorized, "invalid bearer token: %v", err)
+			return
+		}
+
+		route := routeTemplate(r)
+		scopes := auth.Config.RouteScopes[route]
+		if len(scopes) == 0 && adminRoute(route) {
+			//the admin routes need an admin scope even when the config file leaves them out
+			scopes = []string{"admin:*"}
+		}
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+				writeProblem(w, r, client.ProblemForbidden, http.StatusForbidden, "bearer token is missing scope %s", scope)
+				return
+			}
+		}
+
+		tier := claims.Tier
+		if tier == "" {
+			tier = auth.Config.DefaultTier
+		}
+		client := &Client{
+			Name:   claims.Subject,
+			Tier:   tier,
+			Method: "jwt",
+			KeyID:  kid,
+		}
+		next.ServeHTTP(w, r.WithContext(WithClient(r.Context(), client)))
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/onsi/gomega"
+)
+
+//signTestJWT makes a token signed with an HMAC secret or an RSA private key
+func signTestJWT(t *testing.T, kid string, key interface{}, claims map[string]interface{}) string {
+	alg := "HS256"
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		alg = "RS256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+//TestJWTAuth checks tokens are verified and scopes enforced per route
+func TestJWTAuth(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//writes the RSA public key to a local JWKS file
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	dir, err := ioutil.TempDir("", "jwks")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "frontend",
+			"n":   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		}},
+	})
+	jwksFile := filepath.Join(dir, "jwks.json")
+	g.Expect(ioutil.WriteFile(jwksFile, jwks, 0600)).To(gomega.Succeed())
+
+	cfg := JWTConfig{
+		Enabled:     true,
+		Audience:    "cc-service",
+		HMACSecrets: map[string]string{"internal": "shared-secret"},
+		JWKSFile:    jwksFile,
+		DefaultTier: "standard",
+		RouteScopes: map[string][]string{
+			"/v1/creditcard":        {"cards:recommend"},
+			"/v1/admin/keys/reload": {"admin:*"},
+		},
+	}
+	verifier, err := NewJWTVerifier(cfg)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	//records the client the handler sees
+	var seen *Client
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}
+	r.HandleFunc("/v1/creditcard", ok)
+	r.HandleFunc("/v1/admin/keys/reload", ok)
+	r.Use((&JWTAuth{Config: cfg, Verifier: verifier}).Middleware)
+
+	now := time.Now().Unix()
+	claims := func(scope string, exp, nbf int64, aud interface{}) map[string]interface{} {
+		return map[string]interface{}{"sub": "web-frontend", "scope": scope, "exp": exp, "nbf": nbf, "aud": aud}
+	}
+	secret := []byte("shared-secret")
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Path    string
+		Token   string
+		Status  int
+	}{
+		{Message: "should fail as there is no token", Path: "/v1/creditcard", Token: "", Status: http.StatusUnauthorized},
+		{Message: "should fail as the token is signed with another secret", Path: "/v1/creditcard",
+			Token: signTestJWT(t, "internal", []byte("wrong"), claims("cards:recommend", now+60, now-60, "cc-service")), Status: http.StatusUnauthorized},
+		{Message: "should fail as the token has expired", Path: "/v1/creditcard",
+			Token: signTestJWT(t, "internal", secret, claims("cards:recommend", now-60, now-120, "cc-service")), Status: http.StatusUnauthorized},
+		{Message: "should fail as the token is not valid yet", Path: "/v1/creditcard",
+			Token: signTestJWT(t, "internal", secret, claims("cards:recommend", now+120, now+60, "cc-service")), Status: http.StatusUnauthorized},
+		{Message: "should fail as the token is for another audience", Path: "/v1/creditcard",
+			Token: signTestJWT(t, "internal", secret, claims("cards:recommend", now+60, now-60, "other")), Status: http.StatusUnauthorized},
+		{Message: "should fail as the token is missing the route scope", Path: "/v1/admin/keys/reload",
+			Token: signTestJWT(t, "internal", secret, claims("cards:recommend", now+60, now-60, "cc-service")), Status: http.StatusForbidden},
+		{Message: "should fail as a narrower admin scope does not grant the wildcard the route needs", Path: "/v1/admin/keys/reload",
+			Token: signTestJWT(t, "internal", secret, claims("admin:read", now+60, now-60, []string{"cc-service"})), Status: http.StatusForbidden},
+		{Message: "should not fail as the token is granted the admin wildcard", Path: "/v1/admin/keys/reload",
+			Token: signTestJWT(t, "internal", secret, claims("admin:*", now+60, now-60, []string{"cc-service"})), Status: http.StatusOK},
+		{Message: "should not fail as the granted wildcard covers the route scope", Path: "/v1/creditcard",
+			Token: signTestJWT(t, "internal", secret, claims("cards:*", now+60, now-60, "cc-service")), Status: http.StatusOK},
+		{Message: "should not fail as the HS256 token is valid", Path: "/v1/creditcard",
+			Token: signTestJWT(t, "internal", secret, claims("cards:recommend", now+60, now-60, "cc-service")), Status: http.StatusOK},
+		{Message: "should not fail as the RS256 token matches the JWKS key", Path: "/v1/creditcard",
+			Token: signTestJWT(t, "frontend", rsaKey, claims("cards:recommend", now+60, now-60, "cc-service")), Status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.Path, nil)
+			if test.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.Token)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			g.Expect(rr.Code).To(gomega.Equal(test.Status))
+		})
+	}
+
+	//the last request attached the token subject as the client
+	g.Expect(seen).To(gomega.Equal(&Client{Name: "web-frontend", Tier: "standard", Method: "jwt", KeyID: "frontend"}))
+}
+
+//TestJWTAlgorithmConfusion checks a token cannot pick an algorithm its key was not loaded for
+func TestJWTAlgorithmConfusion(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	verifier, err := NewJWTVerifier(JWTConfig{HMACSecrets: map[string]string{"internal": "shared-secret"}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"internal"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"attacker","exp":9999999999}`))
+	_, _, err = verifier.Verify(header + "." + payload + ".")
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(err.Error()).To(gomega.Equal(`token algorithm "none" is not accepted`))
+}
@@ -2,37 +2,29 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"expvar"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
 	"os"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/heroku/go-getting-started/client"
 )
 
-//UserInfo is the information received as a body of the post request to /creditcard
-type UserInfo struct {
-	FirstName   string `json:"firstname" binding:"required"`
-	LastName    string `json:"lastname" binding:"required"`
-	DOB         string `json:"dob" binding:"required"`
-	CreditScore int    `json:"credit-score" binding:"required"`
-	EmpStatus   string `json:"employment-status" binding:"required"`
-	Salary      int    `json:"salary" binding:"required"`
-}
+//UserInfo is the information received as a body of the post request to /creditcard, its fields come from
+//client.UserInfo so the SDK and the server cannot drift apart,
+//fields tagged pii are masked by RedactFields before they reach logs, traces or error messages
+type UserInfo client.UserInfo
 
-//CreditCard is the response of /creditcard endpoint if successful
-type CreditCard struct {
-	Provider  string   `json:"provider"`
-	Name      string   `json:"name"`
-	ApplyURL  string   `json:"apply-url"`
-	Apr       float64  `json:"apr"`
-	Features  []string `json:"features"`
-	CardScore float64  `json:"card-score"`
-}
+//CreditCard is the response of /creditcard endpoint if successful, it is the SDK's model
+type CreditCard = client.CreditCard
 
 //CSCardResponse is the response of /cards endpoint if successful
 type CSCardResponse struct {
@@ -53,51 +45,233 @@ type ScoredCardResponse struct {
 	IntroOffers    []string `json:"introductory-offers,omitempty"`
 }
 
+//CSCardsRequest is the body of the post request to CSCards /cards endpoint
+type CSCardsRequest struct {
+	FullName    string `json:"fullName"`
+	DateOfBirth string `json:"dateOfBirth"`
+	CreditScore int    `json:"creditScore"`
+}
+
+//ScoredCardsRequest is the body of the post request to ScoredCards /creditcards endpoint
+type ScoredCardsRequest struct {
+	FirstName   string `json:"first-name"`
+	LastName    string `json:"last-name"`
+	DateOfBirth string `json:"date-of-birth"`
+	Score       int    `json:"score"`
+	EmpStatus   string `json:"employment-status"`
+	Salary      int    `json:"salary"`
+}
+
+//NewCSCardsRequest maps the user info to the CSCards request body
+func NewCSCardsRequest(userInfo *UserInfo) CSCardsRequest {
+	return CSCardsRequest{
+		FullName:    userInfo.FirstName + " " + userInfo.LastName,
+		DateOfBirth: userInfo.DOB,
+		CreditScore: userInfo.CreditScore,
+	}
+}
+
+//NewScoredCardsRequest maps the user info to the ScoredCards request body
+func NewScoredCardsRequest(userInfo *UserInfo) ScoredCardsRequest {
+	return ScoredCardsRequest{
+		FirstName:   userInfo.FirstName,
+		LastName:    userInfo.LastName,
+		DateOfBirth: userInfo.DOB,
+		Score:       userInfo.CreditScore,
+		EmpStatus:   userInfo.EmpStatus,
+		Salary:      userInfo.Salary,
+	}
+}
+
 //CreditCards contains all credit cards from CSCards and ScoredCards
 type CreditCards []CreditCard
 
-func main() {
-	port := os.Getenv("PORT")
+//ScoringVersion identifies the CardScore formulas and ranking, it is recorded with every audit entry.
+//Version 2 leaves out cards without a positive APR or with a score that is not finite
+const ScoringVersion = "2"
+
+//ProviderError is returned when a provider responds with a body that cannot be used,
+//Body has been scrubbed of the applicant's personal data so it is safe to log
+type ProviderError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+	Message    string
+}
+
+func (err *ProviderError) Error() string {
+	return err.Message
+}
+
+//CSCardsEndpoint is the CSCards API endpoint, $CSCARDS_ENDPOINT overrides the default
+var CSCardsEndpoint = envOrDefault("CSCARDS_ENDPOINT", "https://y4xvbk1ki5.execute-api.us-west-2.amazonaws.com/CS/v1/cards")
 
-	if port == "" {
-		log.Fatal("$PORT must be set")
+//ScoredCardsEndpoint is the ScoredCards API endpoint, $SCOREDCARDS_ENDPOINT overrides the default
+var ScoredCardsEndpoint = envOrDefault("SCOREDCARDS_ENDPOINT", "https://m33dnjs979.execute-api.us-west-2.amazonaws.com/CS/v2/creditcards")
+
+func envOrDefault(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
 	}
+	return fallback
+}
+
+func main() {
+	os.Exit(Run(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-	r := mux.NewRouter()
-	r.HandleFunc("/v1/creditcard", Handler).Methods(http.MethodPost)
-	err := http.ListenAndServe(":"+port, r)
+//NewRouter registers the API routes and wraps them in the middleware chain, stop ends the SIGHUP key reloads
+//and the job workers it starts
+func NewRouter(cfg *Config) (r *mux.Router, stop func(), err error) {
+	keys, err := NewKeyStore(cfg.Auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	verifier, err := NewJWTVerifier(cfg.JWT)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	ui, err := NewUI("templates")
 	if err != nil {
-		log.Fatal("error occurred")
+		return nil, nil, err
+	}
+
+	jobs := NewJobRunner(cfg.Jobs, NewMemoryJobStore())
+	jobs.Decoding = cfg.Decoding
+	//starts the background work only once nothing else can fail
+	stopReload := keys.ReloadOnSignal()
+	jobs.Start()
+	stop = func() {
+		stopReload()
+		jobs.Stop()
+	}
+	idempotency := NewIdempotency(cfg.Idempotency, cfg.Decoding, cfg.RateLimit.TrustForwardedFor)
+
+	r = mux.NewRouter()
+	//the API and the browser pages share the rate limiter, so a client's address has one bucket whichever it calls
+	limiter := NewRateLimiter(cfg.RateLimit)
+
+	api := r.NewRoute().Subrouter()
+	api.HandleFunc("/v1/creditcard", idempotency.Handle(Handler(cfg.Decoding))).Methods(http.MethodPost)
+	api.HandleFunc("/v1/creditcard/stream", StreamHandler(cfg.Decoding)).Methods(http.MethodPost)
+	api.HandleFunc("/v1/creditcard/batch", (&Batch{Config: cfg.Batch, Decoding: cfg.Decoding}).Handler).Methods(http.MethodPost)
+	api.HandleFunc("/v1/jobs/creditcard", idempotency.Handle(jobs.Submit)).Methods(http.MethodPost)
+	api.HandleFunc("/v1/jobs/{id}", jobs.Status).Methods(http.MethodGet)
+	//the admin routes are left out unless clients authenticate, the auth middleware then asks for an admin grant
+	if cfg.Auth.Enabled || cfg.JWT.Enabled {
+		api.HandleFunc("/v1/admin/keys/reload", keys.ReloadHandler).Methods(http.MethodPost)
+		api.Handle("/v1/admin/metrics", expvar.Handler()).Methods(http.MethodGet)
+	}
+	//authenticates first so the rate limiter can key by client and tier, bearer tokens are tried before api keys
+	api.Use((&JWTAuth{Config: cfg.JWT, Verifier: verifier, Fallback: cfg.Auth.Enabled}).Middleware)
+	api.Use((&APIKeyAuth{Enabled: cfg.Auth.Enabled, Store: keys}).Middleware)
+	api.Use(limiter.Middleware)
+	api.Use(RequestDeadline)
+
+	//browsers cannot send api keys or bearer tokens and a CSRF token is not authentication, so the form is left out
+	//when clients have to authenticate, otherwise it would call the providers for anyone
+	if !cfg.Auth.Enabled && !cfg.JWT.Enabled {
+		web := r.NewRoute().Subrouter()
+		web.HandleFunc("/", ui.Form).Methods(http.MethodGet)
+		web.HandleFunc("/", ui.Submit).Methods(http.MethodPost)
+		web.HandleFunc("/stream", ui.Stream).Methods(http.MethodPost)
+		web.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static")))).Methods(http.MethodGet)
+		web.Use(limiter.Middleware)
+		web.Use(RequestDeadline)
+	}
+
+	//gives the request its ID before anything can log or audit it
+	r.Use(RequestIDs)
+	return r, stop, nil
+}
+
+//Handler returns the handler that receives the user info decoded as the config says, passes it to CSCard and
+//ScoredCard APIs, format and sort the responses
+func Handler(decoding DecodeConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recommendHandler(w, r, decoding)
 	}
 }
 
-//Handler receives the user info, passes it to CSCard and ScoredCard APIs, format and sort the responses
-func Handler(w http.ResponseWriter, r *http.Request) {
-	var newUserInfo UserInfo
-	reqBody, err := ioutil.ReadAll(r.Body)
+//recommendHandler answers one applicant in the negotiated format
+func recommendHandler(w http.ResponseWriter, r *http.Request, decoding DecodeConfig) {
+	//picks the response format before asking the providers
+	encoder, contentType, ok := NegotiateEncoder(r.Header.Get("Accept"))
+	w.Header().Add("Vary", "Accept")
+	if !ok {
+		writeProblem(w, r, client.ProblemNotAcceptable, http.StatusNotAcceptable, "not acceptable, available formats are %s", strings.Join(AvailableMediaTypes(), ", "))
+		return
+	}
+
+	newUserInfo, err := DecodeApplicant(r, decoding)
 	if err != nil {
-		w.WriteHeader(400)
-		fmt.Fprintf(w, "please enter user info")
+		writeBodyError(w, r, err)
+		return
+	}
+
+	creditcards, err := Recommend(r.Context(), newUserInfo, RequestID(r))
+	if err == context.Canceled {
+		//the client has gone so there is no one to answer
+		return
+	}
+	if err == context.DeadlineExceeded {
+		writeProblem(w, r, client.ProblemTimeout, http.StatusGatewayTimeout, "the providers did not answer within the request timeout")
 		return
 	}
-	err = json.Unmarshal(reqBody, &newUserInfo)
 	if err != nil {
-		w.WriteHeader(400)
-		fmt.Fprintf(w, "please enter the body in right JSON format")
+		problem := client.NewProblem(client.ProblemProviderUnavailable, 400, err.Error())
+		if recommendErr, ok := err.(*RecommendError); ok {
+			problem.Provider = recommendErr.Provider
+		}
+		WriteProblem(w, r, problem)
 		return
 	}
 
+	//converts the result to the negotiated format for the response
+	var body bytes.Buffer
+	err = encoder.Encode(&body, creditcards)
+	if err != nil {
+		writeProblem(w, r, client.ProblemInternal, 500, "failed to encode the response")
+		return
+	}
+	//responds with http response status code to 200 if successful
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+//RecommendError is returned by Recommend when a provider cannot be used, the cause has already been logged
+type RecommendError struct {
+	Provider string
+}
+
+func (err *RecommendError) Error() string {
+	return "unable to retrieve " + err.Provider
+}
+
+//recommendError is the error for a failed provider call, ctx's error when the call was stopped by it
+func recommendError(ctx context.Context, provider string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return &RecommendError{Provider: provider}
+}
+
+//Recommend asks every provider for the applicant's cards, ranks them and records the decision in the audit log,
+//it is the pipeline behind both the JSON endpoint and the HTML form. The provider calls stop when ctx is done,
+//Recommend then returns context.Canceled or context.DeadlineExceeded
+func Recommend(ctx context.Context, newUserInfo UserInfo, requestID string) ([]CreditCard, error) {
 	//creates an empty result array
 	var creditcards []CreditCard
 
 	//gets credit cards information from CSCards
-	csCardsResults, err := newUserInfo.GetCSCards()
+	csCardsResponses, err := newUserInfo.FetchCSCards(ctx)
+	observeProviderCall(ctx, "CSCards", newUserInfo, requestID, err)
 	if err != nil {
-		w.WriteHeader(400)
-		fmt.Fprintf(w, "unable to retrieve CSCards")
-		return
+		return nil, recommendError(ctx, "CSCards")
 	}
+	csCardsResults, csCardsScores := ScoreCSCards(csCardsResponses)
 
 	//appends the result array with credit cards received from CSCards
 	for _, csCardsResult := range csCardsResults {
@@ -105,74 +279,139 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//gets credit cards information from ScoredCards
-	scoredCardsResults, err := newUserInfo.GetScoredCards()
+	scoredCardsResponses, err := newUserInfo.FetchScoredCards(ctx)
+	observeProviderCall(ctx, "ScoredCards", newUserInfo, requestID, err)
 	if err != nil {
-		w.WriteHeader(400)
-		fmt.Fprintf(w, "unable to retrieve ScoredCards")
-		return
+		return nil, recommendError(ctx, "ScoredCards")
 	}
+	scoredCardsResults, scoredCardsScores := ScoreScoredCards(scoredCardsResponses)
+
 	//appends the result array with credit cards received from ScoredCards
 	for _, scoredCardResult := range scoredCardsResults {
 		creditcards = append(creditcards, scoredCardResult)
 	}
 
+	//gets credit cards information from the providers defined in the config file
+	var adapterAudits []AuditProvider
+	for _, provider := range ConfiguredProviders {
+		adapterResponses, err := provider.Fetch(ctx, &newUserInfo)
+		observeProviderCall(ctx, provider.Config.Name, newUserInfo, requestID, err)
+		if err != nil {
+			return nil, recommendError(ctx, provider.Config.Name)
+		}
+		adapterResults, rawScores := provider.Score(adapterResponses)
+		creditcards = append(creditcards, adapterResults...)
+		adapterAudits = append(adapterAudits, NewAuditProvider(provider.Config.Name, adapterResponses, adapterResults, rawScores, nil))
+	}
+
 	//sorts the result by card score
+	RankCards(creditcards)
+
+	//records the decision in the audit log
+	if DefaultAuditor != nil {
+		DefaultAuditor.Record(&AuditEntry{
+			Time:            time.Now().UTC(),
+			RequestID:       requestID,
+			ClientRequestID: ClientRequestID(ctx),
+			ApplicantHash:   DefaultAuditor.ApplicantHash(newUserInfo),
+			ScoringVersion:  ScoringVersion,
+			Providers: append([]AuditProvider{
+				NewAuditProvider("CSCards", csCardsResponses, csCardsResults, csCardsScores, nil),
+				NewAuditProvider("ScoredCards", scoredCardsResponses, scoredCardsResults, scoredCardsScores, nil),
+			}, adapterAudits...),
+			Ranked: creditcards,
+		})
+	}
+
+	return creditcards, nil
+}
+
+//RankCards sorts the cards by card score, highest first, keeping the provider order for equal scores
+func RankCards(creditcards []CreditCard) {
 	sort.SliceStable(creditcards, func(i, j int) bool {
 		return creditcards[j].CardScore < creditcards[i].CardScore
 	})
+}
 
-	//converts the result to json for the response
-	err = json.NewEncoder(w).Encode(creditcards)
-	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, "failed to response in JSON")
+//logProviderError records why a provider call failed, the applicant is printed through its redacting String method
+func logProviderError(userInfo UserInfo, err error) {
+	if providerErr, ok := err.(*ProviderError); ok {
+		log.Printf("%s responded %d with an unusable body for applicant %v: %s", providerErr.Provider, providerErr.StatusCode, userInfo, providerErr.Body)
+		return
 	}
-	//responds with http response status code to 200 if successful
-	w.WriteHeader(http.StatusOK)
+	log.Printf("provider request failed for applicant %v: %v", userInfo, err)
 }
 
 //GetCSCards sends a post request to CSCard API endpoint and formats the response
-func (userInfo *UserInfo) GetCSCards() ([]CreditCard, error) {
-	//csCardEndPoint := os.Getenv("CSCARDS_ENDPOINT")
-	//above was not working with test environment, put the link here as well as in .env
-	csCardEndPoint := "https://y4xvbk1ki5.execute-api.us-west-2.amazonaws.com/CS/v1/cards"
-
-	//makes a body for the POST request with user information received
-	var jsonStr = []byte(fmt.Sprintf(`{
-		"fullName": "%s %s",
-		"dateOfBirth": "%s",
-		"creditScore": %d
-	}`, userInfo.FirstName, userInfo.LastName, userInfo.DOB, userInfo.CreditScore))
+func (userInfo *UserInfo) GetCSCards(ctx context.Context) ([]CreditCard, error) {
+	csCardResult, err := userInfo.FetchCSCards(ctx)
+	if err != nil {
+		return nil, err
+	}
+	creditCardResults, _ := ScoreCSCards(csCardResult)
+	return creditCardResults, nil
+}
 
-	//makes a POST request with the body from above
-	req, err := http.NewRequest("POST", csCardEndPoint, bytes.NewBuffer(jsonStr))
+//FetchCSCards sends a post request to CSCard API endpoint and returns the cards as the provider sent them,
+//an identical call already in flight is shared and the request is cancelled once every caller sharing it is done
+func (userInfo *UserInfo) FetchCSCards(ctx context.Context) ([]CSCardResponse, error) {
+	result, err := coalesceFetch(ctx, "CSCards", userInfo, func(ctx context.Context) (interface{}, error) {
+		return userInfo.fetchCSCards(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]CSCardResponse), nil
+}
+
+//fetchCSCards makes the call to CSCards for FetchCSCards
+func (userInfo *UserInfo) fetchCSCards(ctx context.Context) ([]CSCardResponse, error) {
+	//makes a body for the POST request with user information received, encoding/json escapes anything the user typed
+	jsonStr, err := json.Marshal(NewCSCardsRequest(userInfo))
 	if err != nil {
 		return nil, fmt.Errorf("unable to make a post request due to the incorrect body")
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	//makes a POST request with the body from above
+	req, err := http.NewRequest("POST", CSCardsEndpoint, bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return nil, fmt.Errorf("unable to make a post request due to the incorrect body")
+	}
+	req = req.WithContext(ctx)
+	//sends the request with the provider's shared client, reading at most its max body size
+	resp, body, err := providerClient("CSCards").post(req)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	//retrieves the response body from the POST request
-	body, err := ioutil.ReadAll(resp.Body)
+	//converts json response to CSCardResponse structure
+	csCardResult, err := DecodeCSCards(body)
 	if err != nil {
-		return nil, err
+		return nil, &ProviderError{
+			Provider:   "CSCards",
+			StatusCode: resp.StatusCode,
+			Body:       ScrubPII(string(body), userInfo),
+			Message:    "unable to reach CSCards API due to the incorrect body",
+		}
 	}
+	return csCardResult, nil
+}
 
+//DecodeCSCards converts the body of a CSCards response to CSCardResponse structs
+func DecodeCSCards(body []byte) ([]CSCardResponse, error) {
 	var csCardResult []CSCardResponse
-
-	var creditCardResults []CreditCard
-
-	//converts json response to CSCardResponse structure
-	err = json.Unmarshal(body, &csCardResult)
+	err := json.Unmarshal(body, &csCardResult)
 	if err != nil {
-		return nil, fmt.Errorf("unable to reach CSCards API due to the incorrect body")
+		return nil, err
 	}
+	return csCardResult, nil
+}
+
+//ScoreCSCards converts the CSCards response to CreditCard structs and calculates their card score,
+//it also returns the eligibility of each card as CSCards sent it
+func ScoreCSCards(csCardResult []CSCardResponse) ([]CreditCard, []float64) {
+	var creditCardResults []CreditCard
+	var rawScores []float64
 
 	//iterates elements of CSCardResponse, convert it to CreditCard struct and appending it to the result array
 	for _, result := range csCardResult {
@@ -185,58 +424,93 @@ func (userInfo *UserInfo) GetCSCards() ([]CreditCard, error) {
 			Features:  result.Features,
 			CardScore: math.Floor((result.Eligibility*sc*10)*1000) / 1000,
 		}
+		//leaves out cards whose score cannot be calculated, such as ones without an APR
+		if !validCardScore(result.Apr, creditCard.CardScore) {
+			continue
+		}
 
 		creditCardResults = append(creditCardResults, creditCard)
+		rawScores = append(rawScores, result.Eligibility)
 	}
 	//returns the result array of all credit cards received
-	return creditCardResults, nil
+	return creditCardResults, rawScores
+}
 
+//validCardScore reports whether a card has a positive APR and a finite score, so it can be ranked and encoded
+func validCardScore(apr, cardScore float64) bool {
+	return apr > 0 && !math.IsInf(cardScore, 0) && !math.IsNaN(cardScore)
 }
 
 //GetScoredCards sends a post request to ScoredCard API endpoint and formats the response
-func (userInfo *UserInfo) GetScoredCards() ([]CreditCard, error) {
-	//scoredCardEndPoint := os.Getenv("SCOREDCARDS_ENDPOINT")
-	//above was not working with test environment, put the link here as well as in .env
-	scoredCardEndPoint := "https://m33dnjs979.execute-api.us-west-2.amazonaws.com/CS/v2/creditcards"
-
-	//makes a body for the POST request with user information received
-	var jsonStr = []byte(fmt.Sprintf(`{
-		"first-name": "%s",
-		"last-name": "%s",
-		"date-of-birth": "%s",
-		"score": %d,
-		"employment-status": "%s",
-		"salary": %d
-	}`, userInfo.FirstName, userInfo.LastName, userInfo.DOB, userInfo.CreditScore, userInfo.EmpStatus, userInfo.Salary))
+func (userInfo *UserInfo) GetScoredCards(ctx context.Context) ([]CreditCard, error) {
+	scoredCardResult, err := userInfo.FetchScoredCards(ctx)
+	if err != nil {
+		return nil, err
+	}
+	creditCardResults, _ := ScoreScoredCards(scoredCardResult)
+	return creditCardResults, nil
+}
 
-	//makes a POST request with the body from above
-	req, err := http.NewRequest("POST", scoredCardEndPoint, bytes.NewBuffer(jsonStr))
+//FetchScoredCards sends a post request to ScoredCard API endpoint and returns the cards as the provider sent them,
+//an identical call already in flight is shared and the request is cancelled once every caller sharing it is done
+func (userInfo *UserInfo) FetchScoredCards(ctx context.Context) ([]ScoredCardResponse, error) {
+	result, err := coalesceFetch(ctx, "ScoredCards", userInfo, func(ctx context.Context) (interface{}, error) {
+		return userInfo.fetchScoredCards(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]ScoredCardResponse), nil
+}
+
+//fetchScoredCards makes the call to ScoredCards for FetchScoredCards
+func (userInfo *UserInfo) fetchScoredCards(ctx context.Context) ([]ScoredCardResponse, error) {
+	//makes a body for the POST request with user information received, encoding/json escapes anything the user typed
+	jsonStr, err := json.Marshal(NewScoredCardsRequest(userInfo))
 	if err != nil {
 		return nil, fmt.Errorf("unable to make a post request due to the incorrect body")
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	//makes a POST request with the body from above
+	req, err := http.NewRequest("POST", ScoredCardsEndpoint, bytes.NewBuffer(jsonStr))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("unable to make a post request due to the incorrect body")
 	}
-	defer resp.Body.Close()
-	//retrieves the response body from the POST request
-	body, err := ioutil.ReadAll(resp.Body)
+	req = req.WithContext(ctx)
+	//sends the request with the provider's shared client, reading at most its max body size
+	resp, body, err := providerClient("ScoredCards").post(req)
 	if err != nil {
 		return nil, err
 	}
 
-	var scoredCardResult []ScoredCardResponse
-
-	var creditCardResults []CreditCard
-
 	//converts json response to ScoredCardResponse structure
-	err = json.Unmarshal(body, &scoredCardResult)
+	scoredCardResult, err := DecodeScoredCards(body)
+	if err != nil {
+		return nil, &ProviderError{
+			Provider:   "ScoredCards",
+			StatusCode: resp.StatusCode,
+			Body:       ScrubPII(string(body), userInfo),
+			Message:    "unable to reach ScoredCards API due to the incorrect body",
+		}
+	}
+	return scoredCardResult, nil
+}
+
+//DecodeScoredCards converts the body of a ScoredCards response to ScoredCardResponse structs
+func DecodeScoredCards(body []byte) ([]ScoredCardResponse, error) {
+	var scoredCardResult []ScoredCardResponse
+	err := json.Unmarshal(body, &scoredCardResult)
 	if err != nil {
-		return nil, fmt.Errorf("unable to reach ScoredCards API due to the incorrect body")
+		return nil, err
 	}
+	return scoredCardResult, nil
+}
+
+//ScoreScoredCards converts the ScoredCards response to CreditCard structs and calculates their card score,
+//it also returns the approval rating of each card as ScoredCards sent it
+func ScoreScoredCards(scoredCardResult []ScoredCardResponse) ([]CreditCard, []float64) {
+	var creditCardResults []CreditCard
+	var rawScores []float64
 	var features []string
 
 	//iterates elements of ScoredCardResponse, convert it to CreditCard struct and appending it to the result array
@@ -258,8 +532,13 @@ func (userInfo *UserInfo) GetScoredCards() ([]CreditCard, error) {
 			Features:  features,
 			CardScore: math.Floor((result.ApprovalRating*100*sc)*1000) / 1000,
 		}
+		//leaves out cards whose score cannot be calculated, such as ones without an APR
+		if !validCardScore(result.Apr, creditCard.CardScore) {
+			continue
+		}
 		creditCardResults = append(creditCardResults, creditCard)
+		rawScores = append(rawScores, result.ApprovalRating)
 	}
 	//returns the result array of all credit cards received
-	return creditCardResults, nil
+	return creditCardResults, rawScores
 }
@@ -2,19 +2,86 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/quick"
+	"unicode/utf8"
 
 	"github.com/onsi/gomega"
 )
 
+//recordCassettes re-records the provider cassettes against the real APIs, run `go test -record` with network access
+var recordCassettes = flag.Bool("record", false, "record provider cassettes against the real APIs")
+
+//testCassetteKey hashes the scrubbed values of the cassettes in testdata, which only hold the test applicants.
+//Set $CASSETTE_SCRUB_KEY to a secret of your own when recording real applicants, and to replay what it recorded
+const testCassetteKey = "go-getting-started test cassettes"
+
+//cassetteKey is the key scrubbed values are hashed with
+func cassetteKey() []byte {
+	if key := os.Getenv("CASSETTE_SCRUB_KEY"); key != "" {
+		return []byte(key)
+	}
+	return []byte(testCassetteKey)
+}
+
+//useCassette serves provider requests from testdata/cassettes/<name>.json, or records them there with -record,
+//the returned func restores the real transport
+func useCassette(t *testing.T, name string) func() {
+	transport, err := NewCassetteTransport(filepath.Join("testdata", "cassettes", name+".json"), *recordCassettes, cassetteKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ProviderTransport = transport
+	return func() {
+		ProviderTransport = nil
+	}
+}
+
+//csCardsFixture and scoredCardsFixture are the provider responses behind the expected cards in these tests
+const csCardsFixture = `[
+	{"cardName": "SuperSaver Card", "url": "http://www.example.com/apply", "apr": 21.4, "eligibility": 6.3},
+	{"cardName": "SuperSpender Card", "url": "http://www.example.com/apply", "apr": 19.2, "eligibility": 5.0, "features": ["Interest free purchases for 6 months"]}
+]`
+const scoredCardsFixture = `[
+	{"card": "ScoredCard Builder", "apply-url": "http://www.example.com/apply", "annual-percentage-rate": 19.4, "approval-rating": 0.8,
+		"attributes": ["Supports ApplePay"], "introductory-offers": ["Interest free purchases for 1 month"]}
+]`
+
+//startFakeProviders points the provider endpoints at local servers answering with the given bodies,
+//the returned func stops them and restores the real endpoints
+func startFakeProviders(csCards, scoredCards string) func() {
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, csCards)
+	}))
+	scored := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, scoredCards)
+	}))
+	csEndpoint, scoredEndpoint := CSCardsEndpoint, ScoredCardsEndpoint
+	CSCardsEndpoint, ScoredCardsEndpoint = cs.URL, scored.URL
+	return func() {
+		cs.Close()
+		scored.Close()
+		CSCardsEndpoint, ScoredCardsEndpoint = csEndpoint, scoredEndpoint
+	}
+}
+
 //TestHandler makes a mock http request and tests if the response is correct
 func TestHandler(t *testing.T) {
 	//test tool
 	g := gomega.NewGomegaWithT(t)
+	defer useCassette(t, "handler")()
 
 	//makes a mock request body for POST request for creditcard
 	reqBody := []byte(`{
@@ -35,7 +102,7 @@ func TestHandler(t *testing.T) {
 
 	//creates a ResponseRecorder to record the response.
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(Handler)
+	handler := Handler(DecodeConfig{})
 
 	//directly passes in the Request and ResponseRecorder.
 	handler.ServeHTTP(rr, req)
@@ -86,6 +153,7 @@ func TestHandler(t *testing.T) {
 func TestGetCSCards(t *testing.T) {
 	//test tool
 	g := gomega.NewGomegaWithT(t)
+	defer useCassette(t, "cscards")()
 	//makes test cases with struct in order to iterate instead of repeating
 	tests := []struct {
 		Message string
@@ -136,7 +204,7 @@ func TestGetCSCards(t *testing.T) {
 	//iterates the tests, checks error codes an compares the response body with the expected response above
 	for _, test := range tests {
 		t.Run(test.Message, func(t *testing.T) {
-			creditcards, err := test.UInfo.GetCSCards()
+			creditcards, err := test.UInfo.GetCSCards(context.Background())
 			if err != nil {
 				g.Expect(err).To(gomega.HaveOccurred())
 				g.Expect(err.Error()).To(gomega.Equal(test.Error))
@@ -148,9 +216,11 @@ func TestGetCSCards(t *testing.T) {
 	}
 }
 
+//TestGetScoredCards tests if it receives the correct information from ScoredCards API
 func TestGetScoredCards(t *testing.T) {
 	//test tool
 	g := gomega.NewGomegaWithT(t)
+	defer useCassette(t, "scoredcards")()
 	//makes test cases with struct in order to iterate instead of repeating
 	tests := []struct {
 		Message string
@@ -193,7 +263,7 @@ func TestGetScoredCards(t *testing.T) {
 	//iterates the tests, checks error codes an compares the response body with the expected response above
 	for _, test := range tests {
 		t.Run(test.Message, func(t *testing.T) {
-			creditcards, err := test.UInfo.GetScoredCards()
+			creditcards, err := test.UInfo.GetScoredCards(context.Background())
 			if err != nil {
 				g.Expect(err).To(gomega.HaveOccurred())
 				g.Expect(err.Error()).To(gomega.Equal(test.Error))
@@ -204,3 +274,328 @@ func TestGetScoredCards(t *testing.T) {
 		})
 	}
 }
+
+//FuzzCSCardsRequest checks any user strings make a valid CSCards body with exactly its own fields
+func FuzzCSCardsRequest(f *testing.F) {
+	f.Add("John", "Smith", "1991/04/18", 500)
+	f.Add(`John", "creditScore": 999, "x": "`, `\`, "1991/04/18\n", -1)
+	f.Add("Zoë", "O'Brien <script>", "", 0)
+	f.Fuzz(func(t *testing.T, firstName, lastName, dob string, creditScore int) {
+		userInfo := UserInfo{FirstName: firstName, LastName: lastName, DOB: dob, CreditScore: creditScore}
+		body, err := json.Marshal(NewCSCardsRequest(&userInfo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectOnlyFields(t, body, "fullName", "dateOfBirth", "creditScore")
+
+		var decoded CSCardsRequest
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Fatalf("body is not valid JSON: %s", body)
+		}
+		if decoded.CreditScore != creditScore {
+			t.Fatalf("credit score changed from %d to %d", creditScore, decoded.CreditScore)
+		}
+		//invalid UTF-8 is replaced by encoding/json, anything else must arrive unchanged
+		if validUTF8(firstName, lastName, dob) && (decoded.FullName != firstName+" "+lastName || decoded.DateOfBirth != dob) {
+			t.Fatalf("user strings changed on the way to CSCards: %s", body)
+		}
+	})
+}
+
+//FuzzScoredCardsRequest checks any user strings make a valid ScoredCards body with exactly its own fields
+func FuzzScoredCardsRequest(f *testing.F) {
+	f.Add("John", "Smith", "1991/04/18", 500, "FULL_TIME", 30000)
+	f.Add(`John", "score": 999, "x": "`, `\"`, "1991/04/18", 500, `FULL_TIME", "salary": 1e9, "y": "`, 0)
+	f.Fuzz(func(t *testing.T, firstName, lastName, dob string, creditScore int, empStatus string, salary int) {
+		userInfo := UserInfo{FirstName: firstName, LastName: lastName, DOB: dob, CreditScore: creditScore, EmpStatus: empStatus, Salary: salary}
+		body, err := json.Marshal(NewScoredCardsRequest(&userInfo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectOnlyFields(t, body, "first-name", "last-name", "date-of-birth", "score", "employment-status", "salary")
+
+		var decoded ScoredCardsRequest
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Fatalf("body is not valid JSON: %s", body)
+		}
+		if decoded.Score != creditScore || decoded.Salary != salary {
+			t.Fatalf("numbers changed on the way to ScoredCards: %s", body)
+		}
+		if validUTF8(firstName, lastName, dob, empStatus) &&
+			(decoded.FirstName != firstName || decoded.LastName != lastName || decoded.DateOfBirth != dob || decoded.EmpStatus != empStatus) {
+			t.Fatalf("user strings changed on the way to ScoredCards: %s", body)
+		}
+	})
+}
+
+func validUTF8(values ...string) bool {
+	for _, value := range values {
+		if !utf8.ValidString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+//expectOnlyFields fails unless body is a JSON object with exactly the given fields, so nothing was injected
+func expectOnlyFields(t *testing.T, body []byte, fields ...string) {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("body is not a JSON object: %s", body)
+	}
+	if len(decoded) != len(fields) {
+		t.Fatalf("body has %d fields, want %d: %s", len(decoded), len(fields), body)
+	}
+	for _, field := range fields {
+		if _, ok := decoded[field]; !ok {
+			t.Fatalf("body is missing %s: %s", field, body)
+		}
+	}
+}
+
+//cassetteResponseBodies returns the provider response bodies recorded in a cassette, to seed the fuzz corpora with real payloads
+func cassetteResponseBodies(f *testing.F, name string) []string {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "cassettes", name+".json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(body, &cassette); err != nil {
+		f.Fatal(err)
+	}
+	var bodies []string
+	for _, interaction := range cassette.Interactions {
+		bodies = append(bodies, interaction.Response.Body)
+	}
+	return bodies
+}
+
+//expectRankable fails unless every card can be ranked and encoded, one raw score per card
+func expectRankable(t *testing.T, cards []CreditCard, rawScores []float64) {
+	if len(cards) != len(rawScores) {
+		t.Fatalf("%d cards but %d raw scores", len(cards), len(rawScores))
+	}
+	for _, card := range cards {
+		if math.IsInf(card.CardScore, 0) || math.IsNaN(card.CardScore) || card.Apr <= 0 {
+			t.Fatalf("card %q has apr %v and score %v", card.Name, card.Apr, card.CardScore)
+		}
+	}
+	if _, err := json.Marshal(cards); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//FuzzHandler checks any request gets a 200 with the cards or a 400, 406, 413 or 415, and never a panic
+func FuzzHandler(f *testing.F) {
+	applicant := `{"firstname": "John", "lastname": "Smith", "dob": "1991/04/18", "credit-score": 500, "employment-status": "FULL_TIME", "salary": 30000}`
+	f.Add([]byte(applicant), "application/json", "")
+	f.Add([]byte(`{"firstname": "John", "credit-score": "500"}`), "", "")
+	f.Add([]byte(`{"credit-score": 1e400}`), "", "")
+	f.Add([]byte(`[{}]`), "", "")
+	f.Add([]byte(``), "", "")
+	//a body over the limit, a media type that is not JSON and a format there is no encoder for
+	f.Add([]byte(strings.Repeat(" ", 64<<10)+applicant), "application/json", "")
+	f.Add([]byte(applicant), "text/plain", "")
+	f.Add([]byte(applicant), "", "image/png")
+	f.Add([]byte(applicant), "application/json", "text/csv")
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	f.Cleanup(stop)
+
+	f.Fuzz(func(t *testing.T, body []byte, contentType string, accept string) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/creditcard", bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		Handler(DecodeConfig{})(rr, req)
+		switch rr.Code {
+		case http.StatusOK:
+			if !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+				return
+			}
+			var creditcards []CreditCard
+			if err := json.Unmarshal(rr.Body.Bytes(), &creditcards); err != nil {
+				t.Fatalf("200 response is not a list of cards: %s", rr.Body.String())
+			}
+		case 400, http.StatusNotAcceptable, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType:
+		default:
+			t.Fatalf("unexpected status %d", rr.Code)
+		}
+	})
+}
+
+//FuzzDecodeCSCards checks any CSCards body either fails to decode or scores to rankable cards
+func FuzzDecodeCSCards(f *testing.F) {
+	for _, body := range cassetteResponseBodies(f, "cscards") {
+		f.Add([]byte(body))
+	}
+	f.Add([]byte(`[{"cardName": "Free", "apr": 0, "eligibility": 10}, {"apr": -1}, {"apr": 1e-300, "eligibility": 1e300}]`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		responses, err := DecodeCSCards(body)
+		if err != nil {
+			return
+		}
+		cards, rawScores := ScoreCSCards(responses)
+		expectRankable(t, cards, rawScores)
+	})
+}
+
+//FuzzDecodeScoredCards checks any ScoredCards body either fails to decode or scores to rankable cards
+func FuzzDecodeScoredCards(f *testing.F) {
+	for _, body := range cassetteResponseBodies(f, "scoredcards") {
+		f.Add([]byte(body))
+	}
+	f.Add([]byte(`[{"card": "Free", "annual-percentage-rate": 0, "approval-rating": 1}, {"annual-percentage-rate": 1e-300, "approval-rating": 1e300}]`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		responses, err := DecodeScoredCards(body)
+		if err != nil {
+			return
+		}
+		cards, rawScores := ScoreScoredCards(responses)
+		expectRankable(t, cards, rawScores)
+	})
+}
+
+//scoreOne is the card score each built-in provider gives one card, or NaN when the card is left out
+func scoreOne(provider string, eligibility, apr float64) float64 {
+	var cards []CreditCard
+	if provider == "CSCards" {
+		cards, _ = ScoreCSCards([]CSCardResponse{{Apr: apr, Eligibility: eligibility}})
+	} else {
+		cards, _ = ScoreScoredCards([]ScoredCardResponse{{Apr: apr, ApprovalRating: eligibility}})
+	}
+	if len(cards) == 0 {
+		return math.NaN()
+	}
+	return cards[0].CardScore
+}
+
+//TestCardScoreProperties checks the card score is finite, rises with eligibility and falls with APR
+func TestCardScoreProperties(t *testing.T) {
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Provider       string
+		MaxEligibility float64
+	}{
+		{Provider: "CSCards", MaxEligibility: 10},
+		{Provider: "ScoredCards", MaxEligibility: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.Provider, func(t *testing.T) {
+			//maps any two floats into the eligibility and APR ranges providers send
+			eligibility := func(x float64) float64 { return math.Abs(math.Mod(x, test.MaxEligibility)) }
+			apr := func(x float64) float64 { return 0.01 + math.Abs(math.Mod(x, 100)) }
+
+			finite := func(e, a float64) bool {
+				score := scoreOne(test.Provider, e, a)
+				//a left out card is fine, a kept one must have a finite score
+				return math.IsNaN(score) || !math.IsInf(score, 0)
+			}
+			if err := quick.Check(finite, nil); err != nil {
+				t.Error("score is not finite:", err)
+			}
+
+			monotonicInEligibility := func(x, y, a float64) bool {
+				low, high := math.Min(eligibility(x), eligibility(y)), math.Max(eligibility(x), eligibility(y))
+				return scoreOne(test.Provider, low, apr(a)) <= scoreOne(test.Provider, high, apr(a))
+			}
+			if err := quick.Check(monotonicInEligibility, nil); err != nil {
+				t.Error("score falls as eligibility rises:", err)
+			}
+
+			decreasingInApr := func(e, x, y float64) bool {
+				low, high := math.Min(apr(x), apr(y)), math.Max(apr(x), apr(y))
+				return scoreOne(test.Provider, eligibility(e), low) >= scoreOne(test.Provider, eligibility(e), high)
+			}
+			if err := quick.Check(decreasingInApr, nil); err != nil {
+				t.Error("score rises as APR rises:", err)
+			}
+		})
+	}
+}
+
+//TestRankCardsStable checks cards are ranked by descending score with ties kept in provider order
+func TestRankCardsStable(t *testing.T) {
+	stable := func(scores []uint8) bool {
+		var cards []CreditCard
+		for i, score := range scores {
+			//only four distinct scores so there are plenty of ties
+			cards = append(cards, CreditCard{Name: strconv.Itoa(i), CardScore: float64(score%4) / 10})
+		}
+		RankCards(cards)
+		for i := 1; i < len(cards); i++ {
+			previous, _ := strconv.Atoi(cards[i-1].Name)
+			current, _ := strconv.Atoi(cards[i].Name)
+			if cards[i].CardScore > cards[i-1].CardScore {
+				return false
+			}
+			if cards[i].CardScore == cards[i-1].CardScore && current < previous {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(stable, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+//benchmarkBody is the applicant posted by the Handler benchmarks
+var benchmarkBody = []byte(`{"firstname": "John", "lastname": "Smith", "dob": "1991/04/18", "credit-score": 500, "employment-status": "FULL_TIME", "salary": 30000}`)
+
+//BenchmarkHandler measures a whole request against local fake providers, run with -benchmem for allocations
+func BenchmarkHandler(b *testing.B) {
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rr := httptest.NewRecorder()
+		Handler(DecodeConfig{})(rr, httptest.NewRequest(http.MethodPost, "/v1/creditcard", bytes.NewReader(benchmarkBody)))
+		if rr.Code != http.StatusOK {
+			b.Fatalf("handler responded %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+}
+
+//BenchmarkHandlerParallel measures the Handler with concurrent requests sharing the provider connections
+func BenchmarkHandlerParallel(b *testing.B) {
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rr := httptest.NewRecorder()
+			Handler(DecodeConfig{})(rr, httptest.NewRequest(http.MethodPost, "/v1/creditcard", bytes.NewReader(benchmarkBody)))
+			if rr.Code != http.StatusOK {
+				b.Errorf("handler responded %d: %s", rr.Code, rr.Body.String())
+			}
+		}
+	})
+}
+
+//BenchmarkScoreAndRank measures scoring and ranking alone, without the provider round trips
+func BenchmarkScoreAndRank(b *testing.B) {
+	csCards, err := DecodeCSCards([]byte(csCardsFixture))
+	if err != nil {
+		b.Fatal(err)
+	}
+	scoredCards, err := DecodeScoredCards([]byte(scoredCardsFixture))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cards, _ := ScoreCSCards(csCards)
+		scored, _ := ScoreScoredCards(scoredCards)
+		RankCards(append(cards, scored...))
+	}
+}
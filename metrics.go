@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+)
+
+//ProviderCalls counts how every provider call ended, by provider then by outcome: "ok", "failed", or "cancelled" and
+//"deadline-exceeded" for calls stopped because the client went away or ran out of time, it is served at /v1/admin/metrics
+var ProviderCalls = expvar.NewMap("provider_calls")
+
+//providerCallsMu makes adding a provider's map to ProviderCalls atomic
+var providerCallsMu sync.Mutex
+
+//countProviderCall adds one to the provider's outcome
+func countProviderCall(provider, outcome string) {
+	providerCallsMu.Lock()
+	defer providerCallsMu.Unlock()
+	counts, ok := ProviderCalls.Get(provider).(*expvar.Map)
+	if !ok {
+		counts = new(expvar.Map).Init()
+		ProviderCalls.Set(provider, counts)
+	}
+	counts.Add(outcome, 1)
+}
+
+//observeProviderCall counts how a provider call ended and logs it when it did not succeed,
+//calls stopped by ctx are cancellations and are logged without blaming the provider
+func observeProviderCall(ctx context.Context, provider string, userInfo UserInfo, requestID string, err error) {
+	switch {
+	case err == nil:
+		countProviderCall(provider, "ok")
+	case ctx.Err() == context.DeadlineExceeded:
+		countProviderCall(provider, "deadline-exceeded")
+		log.Printf("request %s ran out of time waiting for %s", requestID, provider)
+	case ctx.Err() != nil:
+		countProviderCall(provider, "cancelled")
+		log.Printf("request %s was cancelled while waiting for %s", requestID, provider)
+	default:
+		countProviderCall(provider, "failed")
+		logProviderError(userInfo, err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+//providerCallCount reads one outcome of ProviderCalls
+func providerCallCount(provider, outcome string) int64 {
+	counts, ok := ProviderCalls.Get(provider).(*expvar.Map)
+	if !ok {
+		return 0
+	}
+	count, ok := counts.Get(outcome).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return count.Value()
+}
+
+//TestObserveProviderCall checks calls stopped by their context are not counted as provider failures
+func TestObserveProviderCall(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Ctx     context.Context
+		Err     error
+		Outcome string
+	}{
+		{Message: "should count a call that succeeded", Ctx: context.Background(), Err: nil, Outcome: "ok"},
+		{Message: "should count a call that failed", Ctx: context.Background(), Err: fmt.Errorf("connection refused"), Outcome: "failed"},
+		{Message: "should count a call the client cancelled", Ctx: cancelled, Err: context.Canceled, Outcome: "cancelled"},
+		{Message: "should count a call that ran out of time", Ctx: expired, Err: context.DeadlineExceeded, Outcome: "deadline-exceeded"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			before := providerCallCount("Metrics", test.Outcome)
+			observeProviderCall(test.Ctx, "Metrics", testApplicant, "metrics", test.Err)
+			g.Expect(providerCallCount("Metrics", test.Outcome)).To(gomega.Equal(before + 1))
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/heroku/go-getting-started/client"
+)
+
+//acceptsProblem reports whether the client asked for RFC 7807 errors, clients that did not keep the plain text messages
+func acceptsProblem(r *http.Request) bool {
+	for _, m := range parseAccept(r.Header.Get("Accept")) {
+		if m.mediaType == client.ProblemContentType && m.q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//WriteProblem answers with an error, as problem+json if the client accepts it and as its detail in plain text otherwise
+func WriteProblem(w http.ResponseWriter, r *http.Request, problem *client.Problem) {
+	//keeps a retry with the same idempotency key from being answered with a problem it may not have
+	if recorder, ok := w.(*idempotencyRecorder); ok && retryableProblem(problem.Type) {
+		recorder.retryable = true
+	}
+	if !acceptsProblem(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(problem.Status)
+		fmt.Fprint(w, problem.Detail)
+		return
+	}
+	w.Header().Set("Content-Type", client.ProblemContentType)
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+//writeProblem is WriteProblem for a problem of a known type
+func writeProblem(w http.ResponseWriter, r *http.Request, problemType string, status int, format string, args ...interface{}) {
+	WriteProblem(w, r, client.NewProblem(problemType, status, strings.TrimSpace(fmt.Sprintf(format, args...))))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/heroku/go-getting-started/client"
+	"github.com/onsi/gomega"
+)
+
+//TestWriteProblem checks clients get problem+json only when they ask for it
+func TestWriteProblem(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message     string
+		Accept      string
+		ContentType string
+		Body        string
+	}{
+		{Message: "should answer in plain text without an Accept header", Accept: "", ContentType: "text/plain; charset=utf-8", Body: "unable to retrieve CSCards"},
+		{Message: "should answer in plain text to JSON only clients", Accept: "application/json", ContentType: "text/plain; charset=utf-8", Body: "unable to retrieve CSCards"},
+		{Message: "should answer with a problem to clients accepting one", Accept: "application/json, application/problem+json", ContentType: "application/problem+json",
+			Body: `{"type":"/problems/provider-unavailable","title":"Provider unavailable","status":400,"detail":"unable to retrieve CSCards","provider":"CSCards"}` + "\n"},
+		{Message: "should answer in plain text as the problem is refused", Accept: "application/problem+json;q=0", ContentType: "text/plain; charset=utf-8", Body: "unable to retrieve CSCards"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/creditcard", nil)
+			req.Header.Set("Accept", test.Accept)
+			rr := httptest.NewRecorder()
+			problem := client.NewProblem(client.ProblemProviderUnavailable, 400, "unable to retrieve CSCards")
+			problem.Provider = "CSCards"
+			WriteProblem(rr, req, problem)
+
+			g.Expect(rr.Code).To(gomega.Equal(400))
+			g.Expect(rr.Header().Get("Content-Type")).To(gomega.Equal(test.ContentType))
+			g.Expect(rr.Body.String()).To(gomega.Equal(test.Body))
+		})
+	}
+}
+
+//TestClientAgainstHandler runs the SDK against the real Handler and middleware
+func TestClientAgainstHandler(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+
+	cfg := DefaultConfig()
+	cfg.Auth = AuthConfig{Enabled: true, Keys: []APIKey{{Hash: HashAPIKey("secret"), Client: "billing", Tier: "standard", Routes: []string{"*"}}}}
+	cfg.RateLimit.Tiers["standard"] = RateLimitTier{RequestsPerMinute: 1, Burst: 2}
+	router, stop, err := NewRouter(cfg)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer stop()
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	c := client.New(server.URL)
+	c.MaxRetries = 0
+	applicant := client.UserInfo{FirstName: "John", LastName: "Smith", DOB: "1991/04/18", CreditScore: 500, EmpStatus: "FULL_TIME", Salary: 30000}
+
+	//without a key the middleware's problem becomes an AuthError
+	_, err = c.Recommend(context.Background(), applicant)
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&client.AuthError{}))
+	g.Expect(err.Error()).To(gomega.Equal("401 Unauthorized: please provide an api key"))
+
+	//with a key the ranked cards come back in the shared model
+	c.APIKey = "secret"
+	cards, err := c.Recommend(context.Background(), applicant)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(cards).To(gomega.HaveLen(3))
+	g.Expect(cards[0]).To(gomega.Equal(client.CreditCard{
+		Provider:  "ScoredCards",
+		Name:      "ScoredCard Builder",
+		ApplyURL:  "http://www.example.com/apply",
+		Apr:       19.4,
+		Features:  []string{"Supports ApplePay", "Interest free purchases for 1 month"},
+		CardScore: 0.212,
+	}))
+
+	//a failing provider is named in a ProviderUnavailableError
+	ScoredCardsEndpoint = server.URL + "/not-a-provider"
+	_, err = c.Recommend(context.Background(), applicant)
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&client.ProviderUnavailableError{}))
+	g.Expect(err.(*client.ProviderUnavailableError).Provider).To(gomega.Equal("ScoredCards"))
+
+	//the burst is used up so the next call is rate limited
+	_, err = c.Recommend(context.Background(), applicant)
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&client.RateLimitedError{}))
+	g.Expect(err.(*client.RateLimitedError).RetryAfter).To(gomega.BeNumerically(">", 0))
+}
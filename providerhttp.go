@@ -0,0 +1,221 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+//ProviderHTTPConfig tunes the HTTP client of a provider, zero values keep the defaults
+type ProviderHTTPConfig struct {
+	//TimeoutMillis caps a whole call, the client's own deadline can only make it shorter
+	TimeoutMillis               int `json:"timeout-millis"`
+	DialTimeoutMillis           int `json:"dial-timeout-millis"`
+	TLSHandshakeTimeoutMillis   int `json:"tls-handshake-timeout-millis"`
+	ResponseHeaderTimeoutMillis int `json:"response-header-timeout-millis"`
+	KeepAliveSeconds            int `json:"keep-alive-seconds"`
+	IdleConnTimeoutSeconds      int `json:"idle-conn-timeout-seconds"`
+	MaxIdleConnsPerHost         int `json:"max-idle-conns-per-host"`
+	//MaxConnsPerHost limits the connections open to the provider at once, 0 does not limit them
+	MaxConnsPerHost int `json:"max-conns-per-host"`
+	//MaxBodyBytes is the largest answer read from the provider, a larger one fails the call
+	MaxBodyBytes int64 `json:"max-body-bytes"`
+	//DisableHTTP2 keeps the provider on HTTP/1.1, HTTP/2 is otherwise used when the provider offers it over TLS
+	DisableHTTP2 bool `json:"disable-http2"`
+}
+
+//DefaultProviderHTTP are the settings of a provider nothing has been configured for
+var DefaultProviderHTTP = ProviderHTTPConfig{
+	TimeoutMillis:               10000,
+	DialTimeoutMillis:           2000,
+	TLSHandshakeTimeoutMillis:   2000,
+	ResponseHeaderTimeoutMillis: 5000,
+	KeepAliveSeconds:            30,
+	IdleConnTimeoutSeconds:      90,
+	MaxIdleConnsPerHost:         32,
+	MaxBodyBytes:                1 << 20,
+}
+
+//HTTPConfig is the provider-http section of the config file, Defaults apply to every provider and
+//Providers override them for one provider by name
+type HTTPConfig struct {
+	Defaults  ProviderHTTPConfig            `json:"defaults"`
+	Providers map[string]ProviderHTTPConfig `json:"providers"`
+}
+
+//Validate checks no setting is negative
+func (cfg HTTPConfig) Validate() error {
+	settings := map[string]ProviderHTTPConfig{"defaults": cfg.Defaults}
+	for name, provider := range cfg.Providers {
+		settings[name] = provider
+	}
+	for name, s := range settings {
+		if s.TimeoutMillis < 0 || s.DialTimeoutMillis < 0 || s.TLSHandshakeTimeoutMillis < 0 || s.ResponseHeaderTimeoutMillis < 0 ||
+			s.KeepAliveSeconds < 0 || s.IdleConnTimeoutSeconds < 0 || s.MaxIdleConnsPerHost < 0 || s.MaxConnsPerHost < 0 || s.MaxBodyBytes < 0 {
+			return fmt.Errorf("provider-http settings for %s cannot be negative", name)
+		}
+	}
+	return nil
+}
+
+//For is the settings of a provider, its own settings over Defaults over DefaultProviderHTTP
+func (cfg HTTPConfig) For(provider string) ProviderHTTPConfig {
+	settings := DefaultProviderHTTP
+	settings.overlay(cfg.Defaults)
+	settings.overlay(cfg.Providers[provider])
+	return settings
+}
+
+//overlay replaces the settings that are set in other
+func (settings *ProviderHTTPConfig) overlay(other ProviderHTTPConfig) {
+	overlayInt(&settings.TimeoutMillis, other.TimeoutMillis)
+	overlayInt(&settings.DialTimeoutMillis, other.DialTimeoutMillis)
+	overlayInt(&settings.TLSHandshakeTimeoutMillis, other.TLSHandshakeTimeoutMillis)
+	overlayInt(&settings.ResponseHeaderTimeoutMillis, other.ResponseHeaderTimeoutMillis)
+	overlayInt(&settings.KeepAliveSeconds, other.KeepAliveSeconds)
+	overlayInt(&settings.IdleConnTimeoutSeconds, other.IdleConnTimeoutSeconds)
+	overlayInt(&settings.MaxIdleConnsPerHost, other.MaxIdleConnsPerHost)
+	overlayInt(&settings.MaxConnsPerHost, other.MaxConnsPerHost)
+	if other.MaxBodyBytes != 0 {
+		settings.MaxBodyBytes = other.MaxBodyBytes
+	}
+	settings.DisableHTTP2 = settings.DisableHTTP2 || other.DisableHTTP2
+}
+
+func overlayInt(setting *int, value int) {
+	if value != 0 {
+		*setting = value
+	}
+}
+
+//ProviderHTTPStats shows each provider's settings and how its connections are used, it is served at /v1/admin/metrics
+var ProviderHTTPStats = expvar.NewMap("provider_http")
+
+//providerHTTP is the long-lived client of one provider, built once and shared by every call to it
+type providerHTTP struct {
+	name      string
+	settings  ProviderHTTPConfig
+	client    *http.Client
+	transport *http.Transport
+	stats     *expvar.Map
+}
+
+//providerHTTPs holds the client of each provider, they are rebuilt when ConfigureProviderHTTP is called
+var providerHTTPs = struct {
+	sync.Mutex
+	cfg     HTTPConfig
+	clients map[string]*providerHTTP
+}{clients: map[string]*providerHTTP{}}
+
+//ConfigureProviderHTTP replaces the settings of the provider clients, the old clients' idle connections are closed
+func ConfigureProviderHTTP(cfg HTTPConfig) {
+	providerHTTPs.Lock()
+	defer providerHTTPs.Unlock()
+	for _, p := range providerHTTPs.clients {
+		p.transport.CloseIdleConnections()
+	}
+	providerHTTPs.cfg = cfg
+	providerHTTPs.clients = map[string]*providerHTTP{}
+}
+
+//providerClient returns the provider's client, building it on first use
+func providerClient(provider string) *providerHTTP {
+	providerHTTPs.Lock()
+	defer providerHTTPs.Unlock()
+	if p, ok := providerHTTPs.clients[provider]; ok {
+		return p
+	}
+
+	settings := providerHTTPs.cfg.For(provider)
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(settings.DialTimeoutMillis) * time.Millisecond,
+		KeepAlive: time.Duration(settings.KeepAliveSeconds) * time.Second,
+	}
+	p := &providerHTTP{
+		name:     provider,
+		settings: settings,
+		transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   time.Duration(settings.TLSHandshakeTimeoutMillis) * time.Millisecond,
+			ResponseHeaderTimeout: time.Duration(settings.ResponseHeaderTimeoutMillis) * time.Millisecond,
+			IdleConnTimeout:       time.Duration(settings.IdleConnTimeoutSeconds) * time.Second,
+			MaxIdleConns:          settings.MaxIdleConnsPerHost,
+			MaxIdleConnsPerHost:   settings.MaxIdleConnsPerHost,
+			MaxConnsPerHost:       settings.MaxConnsPerHost,
+			ForceAttemptHTTP2:     !settings.DisableHTTP2,
+		},
+		stats: new(expvar.Map).Init(),
+	}
+	p.client = &http.Client{Transport: p, Timeout: time.Duration(settings.TimeoutMillis) * time.Millisecond}
+	p.stats.Set("settings", expvar.Func(func() interface{} { return settings }))
+	ProviderHTTPStats.Set(provider, p.stats)
+	providerHTTPs.clients[provider] = p
+	return p
+}
+
+//RoundTrip sends the request through ProviderTransport when one is set and the provider's own transport otherwise,
+//counting whether it got a new or a pooled connection
+func (p *providerHTTP) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.stats.Add("requests", 1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				p.stats.Add("connections-reused", 1)
+			} else {
+				p.stats.Add("connections-new", 1)
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	next := ProviderTransport
+	if next == nil {
+		next = p.transport
+	}
+	resp, err := next.RoundTrip(req)
+	if err == nil && resp.ProtoMajor == 2 {
+		p.stats.Add("http2-responses", 1)
+	}
+	return resp, err
+}
+
+//ProviderBaseTransport sends a request with the transport of the provider its URL belongs to,
+//it is where the fault injection, throttle and recording transports send requests on to
+var ProviderBaseTransport http.RoundTripper = providerBaseTransport{}
+
+type providerBaseTransport struct{}
+
+func (providerBaseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	provider := providerForURL(req.URL)
+	if provider == "" {
+		provider = "other"
+	}
+	return providerClient(provider).transport.RoundTrip(req)
+}
+
+//post sends a JSON body to the provider and reads its answer, up to the provider's MaxBodyBytes
+func (p *providerHTTP) post(req *http.Request) (*http.Response, []byte, error) {
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, p.settings.MaxBodyBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(body)) > p.settings.MaxBodyBytes {
+		p.stats.Add("bodies-too-large", 1)
+		return nil, nil, fmt.Errorf("%s answered with more than %d bytes", p.name, p.settings.MaxBodyBytes)
+	}
+	return resp, body, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+//TestHTTPConfigFor checks a provider's settings win over the defaults, which win over the built in ones
+func TestHTTPConfigFor(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	cfg := HTTPConfig{
+		Defaults:  ProviderHTTPConfig{TimeoutMillis: 3000, MaxBodyBytes: 4096},
+		Providers: map[string]ProviderHTTPConfig{"ScoredCards": {TimeoutMillis: 500, DisableHTTP2: true}},
+	}
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message  string
+		Provider string
+		Timeout  int
+		MaxBody  int64
+		HTTP2    bool
+	}{
+		{Message: "should take the defaults for a provider without settings", Provider: "CSCards", Timeout: 3000, MaxBody: 4096, HTTP2: true},
+		{Message: "should take the provider's own settings", Provider: "ScoredCards", Timeout: 500, MaxBody: 4096, HTTP2: false},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			settings := cfg.For(test.Provider)
+			g.Expect(settings.TimeoutMillis).To(gomega.Equal(test.Timeout))
+			g.Expect(settings.MaxBodyBytes).To(gomega.Equal(test.MaxBody))
+			g.Expect(settings.DisableHTTP2).To(gomega.Equal(!test.HTTP2))
+			g.Expect(settings.DialTimeoutMillis).To(gomega.Equal(DefaultProviderHTTP.DialTimeoutMillis))
+		})
+	}
+
+	g.Expect(HTTPConfig{Providers: map[string]ProviderHTTPConfig{"CSCards": {MaxBodyBytes: -1}}}.Validate()).To(gomega.MatchError("provider-http settings for CSCards cannot be negative"))
+}
+
+//TestProviderClient checks calls share a pooled connection and answers over the body limit fail the call
+func TestProviderClient(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	ConfigureProviderHTTP(HTTPConfig{Providers: map[string]ProviderHTTPConfig{"ScoredCards": {MaxBodyBytes: 64}}})
+	defer ConfigureProviderHTTP(HTTPConfig{})
+
+	g.Expect(providerClient("CSCards")).To(gomega.BeIdenticalTo(providerClient("CSCards")))
+	for i := 0; i < 2; i++ {
+		_, err := testApplicant.FetchCSCards(context.Background())
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+	stats := ProviderHTTPStats.Get("CSCards").(*expvar.Map)
+	g.Expect(stats.Get("requests").String()).To(gomega.Equal("2"))
+	g.Expect(stats.Get("connections-new").String()).To(gomega.Equal("1"))
+	g.Expect(stats.Get("connections-reused").String()).To(gomega.Equal("1"))
+
+	_, err := testApplicant.FetchScoredCards(context.Background())
+	g.Expect(err).To(gomega.MatchError("ScoredCards answered with more than 64 bytes"))
+	g.Expect(ProviderHTTPStats.Get("ScoredCards").(*expvar.Map).Get("bodies-too-large").String()).To(gomega.Equal("1"))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heroku/go-getting-started/client"
+)
+
+//RateLimitTier is the token bucket size and refill rate given to a group of clients
+type RateLimitTier struct {
+	RequestsPerMinute float64 `json:"requests-per-minute"`
+	Burst             int     `json:"burst"`
+}
+
+//RateLimitConfig is the rate-limit section of the config file
+type RateLimitConfig struct {
+	Enabled     bool                     `json:"enabled"`
+	DefaultTier string                   `json:"default-tier"`
+	Tiers       map[string]RateLimitTier `json:"tiers"`
+	//TrustForwardedFor keys anonymous clients by the last X-Forwarded-For hop, as set by the Heroku router
+	TrustForwardedFor bool `json:"trust-forwarded-for"`
+}
+
+//Validate checks every tier can hand out tokens and the default tier exists
+func (cfg RateLimitConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if _, ok := cfg.Tiers[cfg.DefaultTier]; !ok {
+		return fmt.Errorf("rate-limit default tier %q is not defined", cfg.DefaultTier)
+	}
+	for name, tier := range cfg.Tiers {
+		if tier.RequestsPerMinute <= 0 || tier.Burst < 1 {
+			return fmt.Errorf("rate-limit tier %q needs a positive requests-per-minute and burst", name)
+		}
+	}
+	return nil
+}
+
+//RateLimitResult is the state of a bucket after a request has tried to take a token from it
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+//RateLimitStore keeps the token buckets, implement it to share buckets between dynos
+type RateLimitStore interface {
+	Take(key string, tier RateLimitTier, now time.Time) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tier   RateLimitTier
+	tokens float64
+	last   time.Time
+}
+
+//MemoryRateLimitStore keeps the token buckets in process memory, it is the default store
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+//NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+//Take refills the bucket for key by the time passed since it was last used and takes a token if there is one
+func (store *MemoryRateLimitStore) Take(key string, tier RateLimitTier, now time.Time) (RateLimitResult, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	//drops buckets that have refilled completely, they behave the same as a new bucket
+	if now.Sub(store.lastSweep) > time.Minute {
+		for k, b := range store.buckets {
+			if b.refill(now) >= float64(b.tier.Burst) {
+				delete(store.buckets, k)
+			}
+		}
+		store.lastSweep = now
+	}
+
+	b, ok := store.buckets[key]
+	if !ok {
+		b = &tokenBucket{tier: tier, tokens: float64(tier.Burst), last: now}
+		store.buckets[key] = b
+	}
+	b.tier = tier
+	b.tokens = b.refill(now)
+	b.last = now
+
+	result := RateLimitResult{Limit: tier.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = tier.durationFor(1 - b.tokens)
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = tier.durationFor(float64(tier.Burst) - b.tokens)
+	return result, nil
+}
+
+func (b *tokenBucket) refill(now time.Time) float64 {
+	perSecond := b.tier.RequestsPerMinute / 60
+	return math.Min(float64(b.tier.Burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+}
+
+//durationFor is how long the tier takes to refill the given number of tokens
+func (tier RateLimitTier) durationFor(tokens float64) time.Duration {
+	return time.Duration(tokens / tier.RequestsPerMinute * float64(time.Minute))
+}
+
+//RateLimiter is the middleware that rejects clients who have used up their bucket
+type RateLimiter struct {
+	Config RateLimitConfig
+	Store  RateLimitStore
+	//ClientFunc returns the bucket key and tier name for a request, it defaults to the API key or client IP
+	ClientFunc func(r *http.Request) (key string, tier string)
+	Now        func() time.Time
+}
+
+//NewRateLimiter creates a rate limiter backed by the in-memory store
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	rl := &RateLimiter{
+		Config: cfg,
+		Store:  NewMemoryRateLimitStore(),
+		Now:    time.Now,
+	}
+	rl.ClientFunc = rl.defaultClient
+	return rl
+}
+
+//Middleware takes a token for every request and responds 429 when there is none left
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rl.Config.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, tierName := rl.ClientFunc(r)
+		tier, ok := rl.Config.Tiers[tierName]
+		if !ok {
+			tierName = rl.Config.DefaultTier
+			tier = rl.Config.Tiers[tierName]
+		}
+
+		result, err := rl.Store.Take(tierName+":"+key, tier, rl.Now())
+		if err != nil {
+			//lets the request through rather than failing every client when a shared store is down
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			writeProblem(w, r, client.ProblemRateLimited, http.StatusTooManyRequests, "rate limit exceeded, please retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//defaultClient keys requests by the authenticated client, falling back to the client IP. Headers the auth middleware
+//has not verified are never used, a made up API key would otherwise get a fresh bucket
+func (rl *RateLimiter) defaultClient(r *http.Request) (string, string) {
+	if client := ClientFromContext(r.Context()); client != nil {
+		return "client:" + client.Name, client.Tier
+	}
+	return "ip:" + clientIP(r, rl.Config.TrustForwardedFor), rl.Config.DefaultTier
+}
+
+//clientIP returns the address the request came from
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
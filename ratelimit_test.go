@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+//TestMemoryRateLimitStore checks the bucket empties after the burst and refills over time
+func TestMemoryRateLimitStore(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	store := NewMemoryRateLimitStore()
+	tier := RateLimitTier{RequestsPerMinute: 60, Burst: 2}
+	now := time.Date(2019, 11, 17, 12, 0, 0, 0, time.UTC)
+
+	//the first two requests use up the burst
+	result, err := store.Take("client", tier, now)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.Allowed).To(gomega.BeTrue())
+	g.Expect(result.Remaining).To(gomega.Equal(1))
+	result, _ = store.Take("client", tier, now)
+	g.Expect(result.Allowed).To(gomega.BeTrue())
+	g.Expect(result.Remaining).To(gomega.Equal(0))
+
+	//the third is rejected and told to retry once a token has refilled
+	result, _ = store.Take("client", tier, now)
+	g.Expect(result.Allowed).To(gomega.BeFalse())
+	g.Expect(result.RetryAfter).To(gomega.Equal(time.Second))
+
+	//other clients have their own bucket
+	result, _ = store.Take("other", tier, now)
+	g.Expect(result.Allowed).To(gomega.BeTrue())
+
+	//one token refills after a second at 60 requests per minute
+	result, _ = store.Take("client", tier, now.Add(time.Second))
+	g.Expect(result.Allowed).To(gomega.BeTrue())
+}
+
+//TestRateLimiterMiddleware checks the headers and the 429 response
+func TestRateLimiterMiddleware(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	rl := NewRateLimiter(RateLimitConfig{
+		Enabled:     true,
+		DefaultTier: "standard",
+		Tiers: map[string]RateLimitTier{
+			"standard": {RequestsPerMinute: 30, Burst: 1},
+		},
+	})
+	now := time.Date(2019, 11, 17, 12, 0, 0, 0, time.UTC)
+	rl.Now = func() time.Time { return now }
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message    string
+		RemoteAddr string
+		APIKey     string
+		Status     int
+		Remaining  string
+		RetryAfter string
+	}{
+		{Message: "should pass as the client has a token", RemoteAddr: "10.0.0.1:1234", Status: http.StatusOK, Remaining: "0", RetryAfter: ""},
+		{Message: "should fail as the client has used its token", RemoteAddr: "10.0.0.1:4321", Status: http.StatusTooManyRequests, Remaining: "0", RetryAfter: "2"},
+		{Message: "should pass as another client has its own bucket", RemoteAddr: "10.0.0.2:1234", Status: http.StatusOK, Remaining: "0", RetryAfter: ""},
+		{Message: "should fail as an unverified api key does not get a bucket of its own", RemoteAddr: "10.0.0.2:1234", APIKey: "made-up", Status: http.StatusTooManyRequests, Remaining: "0", RetryAfter: "2"},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/creditcard", nil)
+			req.RemoteAddr = test.RemoteAddr
+			if test.APIKey != "" {
+				req.Header.Set("X-API-Key", test.APIKey)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			g.Expect(rr.Code).To(gomega.Equal(test.Status))
+			g.Expect(rr.Header().Get("RateLimit-Limit")).To(gomega.Equal("1"))
+			g.Expect(rr.Header().Get("RateLimit-Remaining")).To(gomega.Equal(test.Remaining))
+			g.Expect(rr.Header().Get("Retry-After")).To(gomega.Equal(test.RetryAfter))
+		})
+	}
+}
+
+//TestClientIP checks which address anonymous clients are keyed by
+func TestClientIP(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/creditcard", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+
+	g.Expect(clientIP(req, false)).To(gomega.Equal("10.0.0.1"))
+	g.Expect(clientIP(req, true)).To(gomega.Equal("2.2.2.2"))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//RedactedValue is written in place of personal data in logs, traces and error messages
+const RedactedValue = "[REDACTED]"
+
+//maxScrubbedBody is how much of a provider error body is kept after scrubbing
+const maxScrubbedBody = 512
+
+//datePattern matches dates of birth however the provider chose to echo them back
+var datePattern = regexp.MustCompile(`\b\d{4}[/-]\d{1,2}[/-]\d{1,2}\b|\b\d{1,2}[/-]\d{1,2}[/-]\d{4}\b`)
+
+//RedactFields returns the JSON fields of a struct with every field tagged `pii:"mask"` masked
+//and every field tagged `pii:"omit"` left out, it is what logs and traces should record
+func RedactFields(v interface{}) map[string]interface{} {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	fields := map[string]interface{}{}
+	if value.Kind() != reflect.Struct {
+		return fields
+	}
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		switch field.Tag.Get("pii") {
+		case "omit":
+			continue
+		case "mask":
+			fields[name] = RedactedValue
+		default:
+			if value.Field(i).Kind() == reflect.Struct {
+				fields[name] = RedactFields(value.Field(i).Interface())
+				continue
+			}
+			fields[name] = value.Field(i).Interface()
+		}
+	}
+	return fields
+}
+
+//PIIValues returns the string form of every `pii` tagged field that is set, for scrubbing free text
+func PIIValues(v interface{}) []string {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var values []string
+	for i := 0; i < value.NumField(); i++ {
+		if value.Type().Field(i).Tag.Get("pii") == "" {
+			continue
+		}
+		field := value.Field(i)
+		if field.IsZero() {
+			continue
+		}
+		values = append(values, fmt.Sprint(field.Interface()))
+	}
+	//replaces the longest values first so a last name inside a full name is not left half scrubbed
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[j]) < len(values[i])
+	})
+	return values
+}
+
+//ScrubPII removes the applicant's personal data and anything that looks like a date from text
+//such as a provider error body, and trims it so a large body cannot flood a log line
+func ScrubPII(text string, v interface{}) string {
+	for _, value := range PIIValues(v) {
+		text = strings.Replace(text, value, RedactedValue, -1)
+	}
+	text = datePattern.ReplaceAllString(text, RedactedValue)
+	if len(text) > maxScrubbedBody {
+		text = text[:maxScrubbedBody] + "..."
+	}
+	return text
+}
+
+//String keeps the applicant's personal data out of logs that print a UserInfo with %v or %s
+func (userInfo UserInfo) String() string {
+	body, _ := json.Marshal(RedactFields(userInfo))
+	return string(body)
+}
+
+//GoString keeps the applicant's personal data out of logs that print a UserInfo with %#v
+func (userInfo UserInfo) GoString() string {
+	return "UserInfo" + userInfo.String()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+//testApplicant is an applicant whose personal data must never reach a sink
+var testApplicant = UserInfo{
+	FirstName:   "Johnathan",
+	LastName:    "Smithers",
+	DOB:         "1991/04/18",
+	CreditScore: 512,
+	EmpStatus:   "FULL_TIME",
+	Salary:      31337,
+}
+
+//expectNoPII fails if any of the applicant's personal data appears in the output of a sink
+func expectNoPII(g *gomega.WithT, sink string) {
+	for _, value := range []string{"Johnathan", "Smithers", "1991/04/18", "512", "31337"} {
+		g.Expect(sink).NotTo(gomega.ContainSubstring(value))
+	}
+}
+
+//TestRedactFields checks the pii tagged fields are masked and the rest kept
+func TestRedactFields(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	g.Expect(RedactFields(testApplicant)).To(gomega.Equal(map[string]interface{}{
+		"firstname":         RedactedValue,
+		"lastname":          RedactedValue,
+		"dob":               RedactedValue,
+		"credit-score":      RedactedValue,
+		"employment-status": "FULL_TIME",
+		"salary":            RedactedValue,
+	}))
+}
+
+//TestUserInfoFormatting checks every fmt verb prints the applicant redacted
+func TestUserInfoFormatting(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+		expectNoPII(g, fmt.Sprintf(verb, testApplicant))
+		expectNoPII(g, fmt.Sprintf(verb, &testApplicant))
+	}
+}
+
+//TestScrubPII checks provider error bodies lose the applicant's data
+func TestScrubPII(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	body := `{"error": "applicant Johnathan Smithers born 18-04-1991 with score 512 is not eligible"}`
+	scrubbed := ScrubPII(body, testApplicant)
+	expectNoPII(g, scrubbed)
+	g.Expect(scrubbed).NotTo(gomega.ContainSubstring("18-04-1991"))
+	g.Expect(scrubbed).To(gomega.ContainSubstring("is not eligible"))
+
+	//large bodies are trimmed
+	g.Expect(len(ScrubPII(strings.Repeat("x", 10000), testApplicant))).To(gomega.Equal(maxScrubbedBody + 3))
+}
+
+//TestNoPIIReachesSinks runs the Handler against providers that echo the applicant back in their
+//error bodies and checks neither the log nor the client response carries the applicant's data
+func TestNoPIIReachesSinks(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	//fake providers that reject the request and echo its body back
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("rejected: " + string(body)))
+	}))
+	defer provider.Close()
+	defer func(cs, scored string) { CSCardsEndpoint, ScoredCardsEndpoint = cs, scored }(CSCardsEndpoint, ScoredCardsEndpoint)
+	CSCardsEndpoint = provider.URL + "/CS/v1/cards"
+	ScoredCardsEndpoint = provider.URL + "/CS/v2/creditcards"
+
+	//captures the log output
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	reqBody := []byte(`{
+		"firstname": "Johnathan",
+		"lastname": "Smithers",
+		"dob": "1991/04/18",
+		"credit-score": 512,
+		"employment-status": "FULL_TIME",
+		"salary": 31337
+	}`)
+	rr := httptest.NewRecorder()
+	Handler(DecodeConfig{})(rr, httptest.NewRequest(http.MethodPost, "/v1/creditcard", bytes.NewReader(reqBody)))
+
+	g.Expect(rr.Code).To(gomega.Equal(400))
+	g.Expect(logs.String()).To(gomega.ContainSubstring("CSCards responded 400"))
+	expectNoPII(g, logs.String())
+	expectNoPII(g, rr.Body.String())
+}
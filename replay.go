@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+//CardChange is how one card's place in a recorded ranking differs after re-scoring, a rank of 0 means absent
+type CardChange struct {
+	Provider string
+	Card     string
+	OldRank  int
+	NewRank  int
+	OldScore float64
+	NewScore float64
+}
+
+//ReplayResult is the difference between a recorded recommendation and the current build's
+type ReplayResult struct {
+	RequestID       string
+	RecordedVersion string
+	Changes         []CardChange
+}
+
+//ReplayEntry re-scores and re-ranks the provider responses recorded in an audit entry with the current pipeline
+func ReplayEntry(entry *AuditEntry) (*ReplayResult, error) {
+	var creditcards []CreditCard
+	for _, provider := range entry.Providers {
+		if provider.Error != "" {
+			return nil, fmt.Errorf("%s failed when the request was recorded", provider.Name)
+		}
+		cards, err := rescoreProvider(provider)
+		if err != nil {
+			return nil, err
+		}
+		creditcards = append(creditcards, cards...)
+	}
+	RankCards(creditcards)
+
+	return &ReplayResult{
+		RequestID:       entry.RequestID,
+		RecordedVersion: entry.ScoringVersion,
+		Changes:         diffRankings(entry.Ranked, creditcards),
+	}, nil
+}
+
+//rescoreProvider runs a recorded provider response through the current scoring for that provider
+func rescoreProvider(provider AuditProvider) ([]CreditCard, error) {
+	switch provider.Name {
+	case "CSCards":
+		var responses []CSCardResponse
+		err := json.Unmarshal(provider.Response, &responses)
+		if err != nil {
+			return nil, fmt.Errorf("recorded CSCards response is invalid")
+		}
+		cards, _ := ScoreCSCards(responses)
+		return cards, nil
+	case "ScoredCards":
+		var responses []ScoredCardResponse
+		err := json.Unmarshal(provider.Response, &responses)
+		if err != nil {
+			return nil, fmt.Errorf("recorded ScoredCards response is invalid")
+		}
+		cards, _ := ScoreScoredCards(responses)
+		return cards, nil
+	}
+
+	adapter := FindAdapterProvider(provider.Name)
+	if adapter == nil {
+		return nil, fmt.Errorf("unknown provider %s", provider.Name)
+	}
+	var responses []interface{}
+	err := json.Unmarshal(provider.Response, &responses)
+	if err != nil {
+		return nil, fmt.Errorf("recorded %s response is invalid", provider.Name)
+	}
+	cards, _ := adapter.Score(responses)
+	return cards, nil
+}
+
+//diffRankings lists the cards whose rank or score differs between two rankings, in the order of the new ranking
+func diffRankings(recorded, current []CreditCard) []CardChange {
+	type placed struct {
+		rank  int
+		score float64
+	}
+	key := func(card CreditCard) string {
+		return card.Provider + "\x00" + card.Name
+	}
+	old := map[string]placed{}
+	for i, card := range recorded {
+		old[key(card)] = placed{rank: i + 1, score: card.CardScore}
+	}
+
+	var changes []CardChange
+	seen := map[string]bool{}
+	for i, card := range current {
+		seen[key(card)] = true
+		was := old[key(card)]
+		if was.rank == i+1 && was.score == card.CardScore {
+			continue
+		}
+		changes = append(changes, CardChange{
+			Provider: card.Provider,
+			Card:     card.Name,
+			OldRank:  was.rank,
+			NewRank:  i + 1,
+			OldScore: was.score,
+			NewScore: card.CardScore,
+		})
+	}
+	for i, card := range recorded {
+		if seen[key(card)] {
+			continue
+		}
+		changes = append(changes, CardChange{
+			Provider: card.Provider,
+			Card:     card.Name,
+			OldRank:  i + 1,
+			OldScore: card.CardScore,
+		})
+	}
+	return changes
+}
+
+//String describes the change on one line of the replay report
+func (change CardChange) String() string {
+	name := fmt.Sprintf("%s (%s)", change.Card, change.Provider)
+	switch {
+	case change.OldRank == 0:
+		return fmt.Sprintf("%s: appeared at rank %d, score %.3f", name, change.NewRank, change.NewScore)
+	case change.NewRank == 0:
+		return fmt.Sprintf("%s: disappeared from rank %d, score %.3f", name, change.OldRank, change.OldScore)
+	}
+	return fmt.Sprintf("%s: rank %d -> %d, score %.3f -> %.3f (%+.3f)",
+		name, change.OldRank, change.NewRank, change.OldScore, change.NewScore, change.NewScore-change.OldScore)
+}
+
+//RunReplay is the replay subcommand, it reads audit files and prints how the current build would rank each request differently
+func RunReplay(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	all := flags.Bool("all", false, "list unchanged requests as well")
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "config file defining the provider adapters")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: replay [-all] [-config file] audit.jsonl...\n")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	cfg, err := LoadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	ConfiguredProviders = NewAdapterProviders(cfg.Providers)
+
+	replayed, changed, skipped := 0, 0, 0
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to open %s\n", path)
+			return 1
+		}
+
+		scanner := bufio.NewScanner(file)
+		//audit lines carry whole provider responses, so allows lines well past the default 64KB
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			var entry AuditEntry
+			err = json.Unmarshal(scanner.Bytes(), &entry)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s:%d: not an audit entry\n", path, line)
+				skipped++
+				continue
+			}
+			result, err := ReplayEntry(&entry)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s:%d: request %s skipped: %v\n", path, line, entry.RequestID, err)
+				skipped++
+				continue
+			}
+
+			replayed++
+			if len(result.Changes) > 0 {
+				changed++
+			}
+			if len(result.Changes) == 0 && !*all {
+				continue
+			}
+			fmt.Fprintf(stdout, "%s (scoring version %s -> %s): %d changes\n", result.RequestID, result.RecordedVersion, ScoringVersion, len(result.Changes))
+			for _, change := range result.Changes {
+				fmt.Fprintf(stdout, "  %s\n", change)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to read %s: %v\n", path, err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(stdout, "replayed %d requests: %d changed, %d skipped\n", replayed, changed, skipped)
+	return 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+//recordedEntry is an audit entry whose ranking was made by an older scoring formula
+func recordedEntry() *AuditEntry {
+	return &AuditEntry{
+		RequestID:      "req-1",
+		ScoringVersion: "0",
+		Providers: []AuditProvider{
+			{Name: "CSCards", Response: json.RawMessage(csCardsFixture)},
+			{Name: "ScoredCards", Response: json.RawMessage(scoredCardsFixture)},
+		},
+		Ranked: []CreditCard{
+			{Provider: "CSCards", Name: "SuperSaver Card", CardScore: 0.5},
+			{Provider: "CSCards", Name: "SuperSpender Card", CardScore: 0.135},
+			{Provider: "CSCards", Name: "Retired Card", CardScore: 0.1},
+		},
+	}
+}
+
+//TestReplayEntry checks rank changes, score deltas and appearing and disappearing cards are reported
+func TestReplayEntry(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	result, err := ReplayEntry(recordedEntry())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequestID).To(gomega.Equal("req-1"))
+	g.Expect(result.Changes).To(gomega.Equal([]CardChange{
+		{Provider: "ScoredCards", Card: "ScoredCard Builder", OldRank: 0, NewRank: 1, OldScore: 0, NewScore: 0.212},
+		{Provider: "CSCards", Card: "SuperSaver Card", OldRank: 1, NewRank: 2, OldScore: 0.5, NewScore: 0.137},
+		{Provider: "CSCards", Card: "SuperSpender Card", OldRank: 2, NewRank: 3, OldScore: 0.135, NewScore: 0.135},
+		{Provider: "CSCards", Card: "Retired Card", OldRank: 3, NewRank: 0, OldScore: 0.1, NewScore: 0},
+	}))
+
+	//an entry whose ranking matches the current build has no changes
+	entry := recordedEntry()
+	entry.Ranked = []CreditCard{
+		{Provider: "ScoredCards", Name: "ScoredCard Builder", CardScore: 0.212},
+		{Provider: "CSCards", Name: "SuperSaver Card", CardScore: 0.137},
+		{Provider: "CSCards", Name: "SuperSpender Card", CardScore: 0.135},
+	}
+	result, err = ReplayEntry(entry)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.Changes).To(gomega.BeEmpty())
+
+	//an entry where a provider failed cannot be replayed
+	entry.Providers[1] = AuditProvider{Name: "ScoredCards", Error: "unable to reach ScoredCards API due to the incorrect body"}
+	_, err = ReplayEntry(entry)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+//TestRunReplay checks the report printed for an audit file
+func TestRunReplay(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "replay")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "requests.jsonl")
+	line, _ := json.Marshal(recordedEntry())
+	g.Expect(ioutil.WriteFile(path, append(append(line, '\n'), []byte("not json\n")...), 0600)).To(gomega.Succeed())
+
+	var stdout, stderr bytes.Buffer
+	g.Expect(RunReplay([]string{path}, &stdout, &stderr)).To(gomega.Equal(0))
+	g.Expect(stdout.String()).To(gomega.Equal(`req-1 (scoring version 0 -> ` + ScoringVersion + `): 4 changes
+  ScoredCard Builder (ScoredCards): appeared at rank 1, score 0.212
+  SuperSaver Card (CSCards): rank 1 -> 2, score 0.500 -> 0.137 (-0.363)
+  SuperSpender Card (CSCards): rank 2 -> 3, score 0.135 -> 0.135 (+0.000)
+  Retired Card (CSCards): disappeared from rank 3, score 0.100
+replayed 1 requests: 1 changed, 1 skipped
+`))
+	g.Expect(stderr.String()).To(gomega.ContainSubstring(":2: not an audit entry"))
+
+	//no files is a usage error
+	g.Expect(RunReplay(nil, &stdout, &stderr)).To(gomega.Equal(2))
+}
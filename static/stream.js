@@ -0,0 +1,159 @@
+// Shows the cards as each provider answers by posting the form to /stream and reading its Server-Sent Events.
+// Without fetch streaming, or when /stream refuses the form, the form is posted normally and rendered by the server.
+(function () {
+  'use strict';
+
+  var form = document.querySelector('form[action="/"]');
+  if (!form || !window.fetch || !window.TextDecoder || !window.ReadableStream) {
+    return;
+  }
+
+  var streaming = false;
+  form.addEventListener('submit', function (event) {
+    if (streaming) {
+      return;
+    }
+    event.preventDefault();
+    streaming = true;
+
+    var section = resultsSection();
+    var status = section.querySelector('.stream-status');
+    status.textContent = 'Asking the card providers…';
+
+    fetch('/stream', {
+      method: 'POST',
+      credentials: 'same-origin',
+      headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+      body: new URLSearchParams(new FormData(form))
+    }).then(function (response) {
+      if (!response.ok || !response.body) {
+        throw new Error('refused');
+      }
+      return readEvents(response.body.getReader(), function (name, data) {
+        if (name === 'provider') {
+          renderCards(section, data.ranked, false);
+          status.textContent = data.provider + ' answered, more cards may follow.';
+        } else if (name === 'provider-error') {
+          var note = document.createElement('p');
+          note.className = 'error-message';
+          note.textContent = data.detail + ', its cards are not shown.';
+          section.querySelector('.stream-errors').appendChild(note);
+        } else if (name === 'ranked') {
+          renderCards(section, data, true);
+          status.textContent = data.length + ' cards, best match first.';
+        }
+      });
+    }).then(function () {
+      streaming = false;
+    }, function () {
+      // lets the server render the form with its errors or results
+      form.submit();
+    });
+  });
+
+  // resultsSection replaces any results on the page with an empty section for the stream
+  function resultsSection() {
+    var old = document.querySelector('.results');
+    if (old) {
+      old.parentNode.removeChild(old);
+    }
+    var section = document.createElement('section');
+    section.className = 'results';
+    section.setAttribute('aria-labelledby', 'results-title');
+    section.innerHTML = '<h2 id="results-title">Recommended cards</h2>' +
+      '<p class="stream-status" role="status" aria-live="polite"></p>' +
+      '<div class="stream-errors"></div><ol class="cards" aria-busy="true"></ol>';
+    form.parentNode.appendChild(section);
+    return section;
+  }
+
+  // readEvents calls onEvent with the name and decoded data of each event until the stream ends
+  function readEvents(reader, onEvent) {
+    var decoder = new TextDecoder();
+    var buffer = '';
+    function pump() {
+      return reader.read().then(function (chunk) {
+        if (chunk.done) {
+          return;
+        }
+        buffer += decoder.decode(chunk.value, { stream: true });
+        var end;
+        while ((end = buffer.indexOf('\n\n')) >= 0) {
+          var block = buffer.slice(0, end);
+          buffer = buffer.slice(end + 2);
+          var name = 'message';
+          var data = '';
+          block.split('\n').forEach(function (line) {
+            if (line.indexOf('event: ') === 0) {
+              name = line.slice(7);
+            } else if (line.indexOf('data: ') === 0) {
+              data += line.slice(6);
+            }
+          });
+          onEvent(name, JSON.parse(data));
+        }
+        return pump();
+      });
+    }
+    return pump();
+  }
+
+  // renderCards shows the ranking with the same markup as the server rendered results
+  function renderCards(section, cards, final) {
+    var list = section.querySelector('.cards');
+    list.textContent = '';
+    list.setAttribute('aria-busy', final ? 'false' : 'true');
+    cards.forEach(function (card, i) {
+      var item = document.createElement('li');
+      item.className = 'card';
+      var article = document.createElement('article');
+      article.setAttribute('aria-labelledby', 'card-' + (i + 1));
+      item.appendChild(article);
+
+      var title = document.createElement('h3');
+      title.id = 'card-' + (i + 1);
+      title.textContent = card.name;
+      article.appendChild(title);
+
+      var details = document.createElement('dl');
+      [['Provider', card.provider], ['APR', card.apr.toFixed(1) + '%'], ['Card score', card['card-score'].toFixed(3)]].forEach(function (pair) {
+        var dt = document.createElement('dt');
+        dt.textContent = pair[0];
+        var dd = document.createElement('dd');
+        dd.textContent = pair[1];
+        details.appendChild(dt);
+        details.appendChild(dd);
+      });
+      article.appendChild(details);
+
+      if (card.features && card.features.length) {
+        var heading = document.createElement('h4');
+        heading.textContent = 'Features';
+        article.appendChild(heading);
+        var features = document.createElement('ul');
+        card.features.forEach(function (feature) {
+          var li = document.createElement('li');
+          li.textContent = feature;
+          features.appendChild(li);
+        });
+        article.appendChild(features);
+      }
+
+      var apply = document.createElement('a');
+      apply.className = 'apply';
+      // only links to web pages, as html/template does for the server rendered results
+      if (/^https?:\/\//i.test(card['apply-url'])) {
+        apply.href = card['apply-url'];
+      }
+      apply.rel = 'noopener noreferrer';
+      apply.textContent = 'Apply for ' + card.name;
+      var hidden = document.createElement('span');
+      hidden.className = 'visually-hidden';
+      hidden.textContent = ' with ' + card.provider;
+      apply.appendChild(hidden);
+      article.appendChild(apply);
+
+      list.appendChild(item);
+    });
+  }
+})();
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/heroku/go-getting-started/client"
+)
+
+//EventStreamContentType is the media type of Server-Sent Events
+const EventStreamContentType = "text/event-stream"
+
+//streamProvider is one provider asked by RecommendStream, fetch returns the provider's response for the audit log
+//along with its scored cards and their raw scores
+type streamProvider struct {
+	name  string
+	fetch func(ctx context.Context) (interface{}, []CreditCard, []float64, error)
+}
+
+//streamProviders are CSCards, ScoredCards and the providers defined in the config file
+func streamProviders(userInfo *UserInfo) []streamProvider {
+	providers := []streamProvider{
+		{name: "CSCards", fetch: func(ctx context.Context) (interface{}, []CreditCard, []float64, error) {
+			responses, err := userInfo.FetchCSCards(ctx)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			cards, rawScores := ScoreCSCards(responses)
+			return responses, cards, rawScores, nil
+		}},
+		{name: "ScoredCards", fetch: func(ctx context.Context) (interface{}, []CreditCard, []float64, error) {
+			responses, err := userInfo.FetchScoredCards(ctx)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			cards, rawScores := ScoreScoredCards(responses)
+			return responses, cards, rawScores, nil
+		}},
+	}
+	for _, provider := range ConfiguredProviders {
+		provider := provider
+		providers = append(providers, streamProvider{name: provider.Config.Name, fetch: func(ctx context.Context) (interface{}, []CreditCard, []float64, error) {
+			responses, err := provider.Fetch(ctx, userInfo)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			cards, rawScores := provider.Score(responses)
+			return responses, cards, rawScores, nil
+		}})
+	}
+	return providers
+}
+
+//streamResult is the answer of one provider
+type streamResult struct {
+	index int
+	audit AuditProvider
+	cards []CreditCard
+	err   error
+}
+
+//RecommendStream asks every provider at once and calls emit as each one answers, a "provider" event with the provisional
+//ranking or a "provider-error" event, then a "ranked" event with the cards of every provider that answered.
+//Unlike Recommend a failing provider does not fail the rest, and once ctx is done or emit fails the remaining calls are cancelled
+func RecommendStream(ctx context.Context, newUserInfo UserInfo, requestID string, emit func(event string, data interface{}) error) ([]CreditCard, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	providers := streamProviders(&newUserInfo)
+	results := make(chan streamResult, len(providers))
+	for i, provider := range providers {
+		i, provider := i, provider
+		go func() {
+			responses, cards, rawScores, err := provider.fetch(ctx)
+			observeProviderCall(ctx, provider.name, newUserInfo, requestID, err)
+			results <- streamResult{index: i, audit: NewAuditProvider(provider.name, responses, cards, rawScores, err), cards: cards, err: err}
+		}()
+	}
+
+	creditcards := []CreditCard{}
+	//keeps the audit in provider order whatever order they answer in
+	audits := make([]AuditProvider, len(providers))
+	for range providers {
+		var result streamResult
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case result = <-results:
+		}
+		audits[result.index] = result.audit
+
+		var err error
+		if result.err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			problem := client.NewProblem(client.ProblemProviderUnavailable, 400, (&RecommendError{Provider: result.audit.Name}).Error())
+			problem.Provider = result.audit.Name
+			err = emit("provider-error", problem)
+		} else {
+			creditcards = append(creditcards, result.cards...)
+			ranked := append([]CreditCard{}, creditcards...)
+			RankCards(ranked)
+			err = emit("provider", client.ProviderEvent{Provider: result.audit.Name, Cards: nonNilCards(result.cards), Ranked: ranked})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//sorts the result by card score
+	RankCards(creditcards)
+
+	//records the decision in the audit log
+	if DefaultAuditor != nil {
+		DefaultAuditor.Record(&AuditEntry{
+			Time:            time.Now().UTC(),
+			RequestID:       requestID,
+			ClientRequestID: ClientRequestID(ctx),
+			ApplicantHash:   DefaultAuditor.ApplicantHash(newUserInfo),
+			ScoringVersion:  ScoringVersion,
+			Providers:       audits,
+			Ranked:          creditcards,
+		})
+	}
+
+	return creditcards, emit("ranked", creditcards)
+}
+
+//nonNilCards makes a provider without cards encode as an empty list
+func nonNilCards(cards []CreditCard) []CreditCard {
+	if cards == nil {
+		return []CreditCard{}
+	}
+	return cards
+}
+
+//StreamHandler returns the handler that answers the applicant in the body with Server-Sent Events from
+//RecommendStream, a client that goes away cancels the provider calls still running
+func StreamHandler(decoding DecodeConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		newUserInfo, err := DecodeApplicant(r, decoding)
+		if err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+		streamRecommendations(w, r, newUserInfo)
+	}
+}
+
+//streamRecommendations writes the events of RecommendStream, each one is flushed as soon as it is written
+func streamRecommendations(w http.ResponseWriter, r *http.Request, userInfo UserInfo) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, r, client.ProblemInternal, 500, "streaming is not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", EventStreamContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	//stops proxies such as nginx from buffering the events
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	id := 0
+	emit := func(event string, data interface{}) error {
+		body, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		id++
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, body)
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	requestID := RequestID(r)
+	_, err := RecommendStream(r.Context(), userInfo, requestID, emit)
+	if err != nil {
+		log.Printf("stream %s stopped before every provider answered: %v", requestID, err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heroku/go-getting-started/client"
+	"github.com/onsi/gomega"
+)
+
+//TestRecommendStream checks every provider gets an event with the provisional ranking and a failing one does not fail the rest
+func TestRecommendStream(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	ScoredCardsEndpoint = failing.URL
+
+	events := map[string]interface{}{}
+	var names []string
+	cards, err := RecommendStream(context.Background(), testApplicant, "stream", func(event string, data interface{}) error {
+		names = append(names, event)
+		events[event] = data
+		return nil
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	//the providers answer in any order but the ranking comes last
+	g.Expect(names).To(gomega.ConsistOf("provider", "provider-error", "ranked"))
+	g.Expect(names[2]).To(gomega.Equal("ranked"))
+	provider := events["provider"].(client.ProviderEvent)
+	g.Expect(provider.Provider).To(gomega.Equal("CSCards"))
+	g.Expect(provider.Cards).To(gomega.HaveLen(2))
+	g.Expect(provider.Ranked).To(gomega.Equal(provider.Cards))
+	problem := events["provider-error"].(*client.Problem)
+	g.Expect(problem.Provider).To(gomega.Equal("ScoredCards"))
+	g.Expect(problem.Detail).To(gomega.Equal("unable to retrieve ScoredCards"))
+	g.Expect(events["ranked"]).To(gomega.Equal(cards))
+	g.Expect(cards).To(gomega.HaveLen(2))
+}
+
+//TestStreamHandler checks the events are written as they happen and a client that goes away cancels the provider still running
+func TestStreamHandler(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	//ScoredCards only answers once its request is cancelled
+	cancelled := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//reads the body so the server notices when the connection is closed
+		ioutil.ReadAll(r.Body)
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer hanging.Close()
+	ScoredCardsEndpoint = hanging.URL
+
+	server := httptest.NewServer(StreamHandler(DecodeConfig{}))
+	defer server.Close()
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(jobApplicant))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(resp.StatusCode).To(gomega.Equal(200))
+	g.Expect(resp.Header.Get("Content-Type")).To(gomega.Equal(EventStreamContentType))
+
+	//the CSCards event arrives while ScoredCards is still waiting
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	g.Expect(lines[0]).To(gomega.Equal("id: 1"))
+	g.Expect(lines[1]).To(gomega.Equal("event: provider"))
+	var event client.ProviderEvent
+	g.Expect(json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &event)).To(gomega.Succeed())
+	g.Expect(event.Provider).To(gomega.Equal("CSCards"))
+
+	resp.Body.Close()
+	g.Eventually(cancelled, 5*time.Second).Should(gomega.BeClosed())
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Body    string
+		Code    int
+	}{
+		{Message: "should fail as the body is not JSON", Body: "firstname=John", Code: 400},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			StreamHandler(DecodeConfig{})(rr, httptest.NewRequest(http.MethodPost, "/v1/creditcard/stream", strings.NewReader(test.Body)))
+			g.Expect(rr.Code).To(gomega.Equal(test.Code))
+		})
+	}
+}
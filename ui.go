@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//csrfCookie holds the token the form must echo back, a cross-site page can post the form but cannot read the cookie
+const csrfCookie = "csrf_token"
+
+//dobPattern is the date of birth format the providers expect
+var dobPattern = regexp.MustCompile(`^\d{4}/\d{2}/\d{2}$`)
+
+//EmploymentStatuses are the choices offered by the form
+var EmploymentStatuses = []string{"FULL_TIME", "PART_TIME", "STUDENT", "UNEMPLOYED", "RETIRED"}
+
+//uiFields are the form fields in the order they appear on the page
+var uiFields = []string{"firstname", "lastname", "dob", "credit-score", "employment-status", "salary"}
+
+//UI serves the HTML form at / and renders the ranked cards for the applicant it posts
+type UI struct {
+	Templates *template.Template
+}
+
+//UIPage is what the templates render, Form keeps the posted values so a corrected form does not have to be retyped
+type UIPage struct {
+	CSRFToken          string
+	Form               map[string]string
+	Errors             map[string]string
+	Error              string
+	EmploymentStatuses []string
+	Cards              []CreditCard
+	Submitted          bool
+}
+
+//FieldError is a message for one form field
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+//ErrorSummary lists the field errors in the order the fields appear on the page
+func (page *UIPage) ErrorSummary() []FieldError {
+	var summary []FieldError
+	for _, field := range uiFields {
+		if message, ok := page.Errors[field]; ok {
+			summary = append(summary, FieldError{Field: field, Message: message})
+		}
+	}
+	return summary
+}
+
+//NewUI parses the templates in dir
+func NewUI(dir string) (*UI, error) {
+	templates, err := template.New("").Funcs(template.FuncMap{
+		"inc":  func(i int) int { return i + 1 },
+		"dict": dict,
+	}).ParseGlob(filepath.Join(dir, "*.tmpl.html"))
+	if err != nil {
+		return nil, err
+	}
+	return &UI{Templates: templates}, nil
+}
+
+//Form renders the empty form
+func (ui *UI) Form(w http.ResponseWriter, r *http.Request) {
+	page := &UIPage{Form: map[string]string{}, Errors: map[string]string{}}
+	ui.render(w, r, http.StatusOK, page)
+}
+
+//Submit checks the CSRF token and the fields, then runs the applicant through Recommend and renders the ranking
+func (ui *UI) Submit(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	cookie, cookieErr := r.Cookie(csrfCookie)
+	if err != nil || cookieErr != nil || !validCSRFToken(cookie.Value, r.PostForm.Get("csrf_token")) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("the form has expired, please reload the page and try again"))
+		return
+	}
+
+	page := &UIPage{Form: map[string]string{}, Errors: map[string]string{}, Submitted: true}
+	for _, field := range uiFields {
+		page.Form[field] = strings.TrimSpace(r.PostForm.Get(field))
+	}
+	userInfo, errors := parseUserInfoForm(page.Form)
+	if len(errors) > 0 {
+		page.Errors = errors
+		ui.render(w, r, http.StatusBadRequest, page)
+		return
+	}
+
+	cards, err := Recommend(r.Context(), userInfo, RequestID(r))
+	if err == context.Canceled {
+		//the browser has gone so there is no one to render the page for
+		return
+	}
+	if err != nil {
+		page.Error = err.Error()
+		ui.render(w, r, http.StatusBadGateway, page)
+		return
+	}
+	page.Cards = cards
+	ui.render(w, r, http.StatusOK, page)
+}
+
+//Stream checks the form like Submit and answers with the Server-Sent Events of RecommendStream, the page's script
+//uses it to show cards as each provider answers and posts the form normally when it is refused
+func (ui *UI) Stream(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	cookie, cookieErr := r.Cookie(csrfCookie)
+	if err != nil || cookieErr != nil || !validCSRFToken(cookie.Value, r.PostForm.Get("csrf_token")) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("the form has expired, please reload the page and try again"))
+		return
+	}
+
+	form := map[string]string{}
+	for _, field := range uiFields {
+		form[field] = strings.TrimSpace(r.PostForm.Get(field))
+	}
+	userInfo, errors := parseUserInfoForm(form)
+	if len(errors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("please correct the form"))
+		return
+	}
+	streamRecommendations(w, r, userInfo)
+}
+
+//parseUserInfoForm turns the form values into a UserInfo, with a message for each field that is not usable
+func parseUserInfoForm(form map[string]string) (UserInfo, map[string]string) {
+	errors := map[string]string{}
+	userInfo := UserInfo{
+		FirstName: form["firstname"],
+		LastName:  form["lastname"],
+		DOB:       form["dob"],
+		EmpStatus: form["employment-status"],
+	}
+	if userInfo.FirstName == "" {
+		errors["firstname"] = "Enter a first name."
+	}
+	if userInfo.LastName == "" {
+		errors["lastname"] = "Enter a last name."
+	}
+	if !dobPattern.MatchString(userInfo.DOB) {
+		errors["dob"] = "Enter the date of birth as YYYY/MM/DD."
+	}
+	creditScore, err := strconv.Atoi(form["credit-score"])
+	if err != nil || creditScore < 0 || creditScore > 700 {
+		errors["credit-score"] = "Enter a credit score between 0 and 700."
+	}
+	userInfo.CreditScore = creditScore
+	salary, err := strconv.Atoi(form["salary"])
+	if err != nil || salary < 0 {
+		errors["salary"] = "Enter a yearly salary in whole pounds."
+	}
+	userInfo.Salary = salary
+	valid := false
+	for _, status := range EmploymentStatuses {
+		valid = valid || status == userInfo.EmpStatus
+	}
+	if !valid {
+		errors["employment-status"] = "Choose an employment status."
+	}
+	return userInfo, errors
+}
+
+//render writes a page with a fresh CSRF token, personal data is on the page so it must not be cached or framed
+func (ui *UI) render(w http.ResponseWriter, r *http.Request, status int, page *UIPage) {
+	page.CSRFToken = newCSRFToken()
+	page.EmploymentStatuses = EmploymentStatuses
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    page.CSRFToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; form-action 'self'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+	err := ui.Templates.ExecuteTemplate(w, "index.tmpl.html", page)
+	if err != nil {
+		log.Printf("unable to render the form: %v", err)
+	}
+}
+
+//dict builds the map a template passes to the field template, from alternating keys and values
+func dict(pairs ...interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key, _ := pairs[i].(string)
+		m[key] = pairs[i+1]
+	}
+	return m
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//validCSRFToken compares the cookie and form tokens in constant time
+func validCSRFToken(cookie, form string) bool {
+	return cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(form)) == 1
+}
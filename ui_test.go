@@ -0,0 +1,278 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+//csrfFieldPattern finds the token in the rendered form
+var csrfFieldPattern = regexp.MustCompile(`name="csrf_token" value="([0-9a-f]+)"`)
+
+//loadForm renders the form and returns the CSRF cookie and the token in the page
+func loadForm(g *gomega.WithT, ui *UI) (*http.Cookie, string) {
+	rr := httptest.NewRecorder()
+	ui.Form(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusOK))
+
+	cookies := rr.Result().Cookies()
+	g.Expect(cookies).To(gomega.HaveLen(1))
+	g.Expect(cookies[0].HttpOnly).To(gomega.BeTrue())
+	g.Expect(cookies[0].SameSite).To(gomega.Equal(http.SameSiteStrictMode))
+	match := csrfFieldPattern.FindStringSubmatch(rr.Body.String())
+	g.Expect(match).To(gomega.HaveLen(2))
+	return cookies[0], match[1]
+}
+
+//submitForm posts the form fields with a cookie and token
+func submitForm(ui *UI, cookie *http.Cookie, token string, fields url.Values) *httptest.ResponseRecorder {
+	form := url.Values{"csrf_token": {token}}
+	for name, values := range fields {
+		form[name] = values
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	ui.Submit(rr, req)
+	return rr
+}
+
+//applicantForm is the form filled in for the test applicant
+func applicantForm() url.Values {
+	return url.Values{
+		"firstname":         {"John"},
+		"lastname":          {"Smith"},
+		"dob":               {"1991/04/18"},
+		"credit-score":      {"500"},
+		"employment-status": {"FULL_TIME"},
+		"salary":            {"30000"},
+	}
+}
+
+//TestUIForm checks the form is accessible and the page is kept out of caches and frames
+func TestUIForm(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	ui, err := NewUI("templates")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	rr := httptest.NewRecorder()
+	ui.Form(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusOK))
+	g.Expect(rr.Header().Get("Content-Type")).To(gomega.Equal("text/html; charset=utf-8"))
+	g.Expect(rr.Header().Get("Cache-Control")).To(gomega.Equal("no-store"))
+	g.Expect(rr.Header().Get("X-Frame-Options")).To(gomega.Equal("DENY"))
+
+	page := rr.Body.String()
+	g.Expect(page).To(gomega.ContainSubstring(`<html lang="en">`))
+	for _, field := range uiFields {
+		g.Expect(page).To(gomega.ContainSubstring(`<label for="` + field + `">`))
+		g.Expect(page).To(gomega.ContainSubstring(`id="` + field + `" name="` + field + `"`))
+	}
+	g.Expect(page).To(gomega.ContainSubstring(`aria-describedby="dob-hint"`))
+	g.Expect(page).NotTo(gomega.ContainSubstring(`results-title`))
+}
+
+//TestUISubmit checks the posted applicant goes through the pipeline and the ranking is rendered
+func TestUISubmit(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	ui, err := NewUI("templates")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cookie, token := loadForm(g, ui)
+
+	rr := submitForm(ui, cookie, token, applicantForm())
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusOK))
+	page := rr.Body.String()
+	g.Expect(page).To(gomega.ContainSubstring(`<h2 id="results-title">Recommended cards</h2>`))
+	g.Expect(page).To(gomega.ContainSubstring(`<p>3 cards, best match first.</p>`))
+
+	//the cards are listed in rank order with their details
+	builder := strings.Index(page, `<h3 id="card-1">ScoredCard Builder</h3>`)
+	saver := strings.Index(page, `<h3 id="card-2">SuperSaver Card</h3>`)
+	spender := strings.Index(page, `<h3 id="card-3">SuperSpender Card</h3>`)
+	g.Expect(builder).To(gomega.BeNumerically(">", 0))
+	g.Expect(saver).To(gomega.BeNumerically(">", builder))
+	g.Expect(spender).To(gomega.BeNumerically(">", saver))
+	g.Expect(page).To(gomega.ContainSubstring(`<dt>APR</dt><dd>19.4%</dd>`))
+	g.Expect(page).To(gomega.ContainSubstring(`<li>Supports ApplePay</li>`))
+	g.Expect(page).To(gomega.ContainSubstring(`<a class="apply" href="http://www.example.com/apply" rel="noopener noreferrer">Apply for ScoredCard Builder`))
+
+	//the form keeps what was entered
+	g.Expect(page).To(gomega.ContainSubstring(`value="John"`))
+	g.Expect(page).To(gomega.ContainSubstring(`<option value="FULL_TIME" selected>`))
+}
+
+//TestUIStream checks the page's script gets the events for a valid form and is refused otherwise so it posts the form
+func TestUIStream(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(csCardsFixture, scoredCardsFixture)
+	defer stop()
+	ui, err := NewUI("templates")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cookie, token := loadForm(g, ui)
+	invalid := applicantForm()
+	invalid.Set("dob", "18/04/1991")
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Cookie  *http.Cookie
+		Fields  url.Values
+		Code    int
+	}{
+		{Message: "should stream the events of a valid form", Cookie: cookie, Fields: applicantForm(), Code: 200},
+		{Message: "should refuse the form without the CSRF cookie", Cookie: nil, Fields: applicantForm(), Code: 403},
+		{Message: "should refuse the form with an invalid field", Cookie: cookie, Fields: invalid, Code: 400},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			form := url.Values{"csrf_token": {token}}
+			for name, values := range test.Fields {
+				form[name] = values
+			}
+			req := httptest.NewRequest(http.MethodPost, "/stream", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.Cookie != nil {
+				req.AddCookie(test.Cookie)
+			}
+			rr := httptest.NewRecorder()
+			ui.Stream(rr, req)
+
+			g.Expect(rr.Code).To(gomega.Equal(test.Code))
+			if test.Code == 200 {
+				g.Expect(rr.Header().Get("Content-Type")).To(gomega.Equal(EventStreamContentType))
+				g.Expect(rr.Body.String()).To(gomega.ContainSubstring("event: ranked\ndata: [{\"provider\":\"ScoredCards\",\"name\":\"ScoredCard Builder\""))
+			}
+		})
+	}
+}
+
+//TestUISubmitRejected checks the CSRF token, field validation and provider failures
+func TestUISubmitRejected(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	ui, err := NewUI("templates")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cookie, token := loadForm(g, ui)
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message  string
+		Cookie   *http.Cookie
+		Token    string
+		Field    string
+		Value    string
+		Status   int
+		Contains string
+	}{
+		{Message: "should fail as there is no CSRF cookie", Cookie: nil, Token: token, Status: http.StatusForbidden, Contains: "the form has expired"},
+		{Message: "should fail as the CSRF token does not match", Cookie: cookie, Token: "forged", Status: http.StatusForbidden, Contains: "the form has expired"},
+		{Message: "should fail as the date of birth is badly formatted", Cookie: cookie, Token: token, Field: "dob", Value: "18-04-1991", Status: http.StatusBadRequest,
+			Contains: `<li><a href="#dob">Enter the date of birth as YYYY/MM/DD.</a></li>`},
+		{Message: "should fail as the credit score is out of range", Cookie: cookie, Token: token, Field: "credit-score", Value: "900", Status: http.StatusBadRequest,
+			Contains: `aria-invalid="true" aria-describedby="credit-score-hint credit-score-error"`},
+		{Message: "should fail as the employment status is not offered", Cookie: cookie, Token: token, Field: "employment-status", Value: "ASTRONAUT", Status: http.StatusBadRequest,
+			Contains: `<p id="employment-status-error" class="error-message"><span class="visually-hidden">Error:</span> Choose an employment status.</p>`},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			form := applicantForm()
+			if test.Field != "" {
+				form.Set(test.Field, test.Value)
+			}
+			rr := submitForm(ui, test.Cookie, test.Token, form)
+			g.Expect(rr.Code).To(gomega.Equal(test.Status))
+			g.Expect(rr.Body.String()).To(gomega.ContainSubstring(test.Contains))
+		})
+	}
+
+	//a provider failure is shown rather than an empty ranking
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer provider.Close()
+	defer func(cs string) { CSCardsEndpoint = cs }(CSCardsEndpoint)
+	CSCardsEndpoint = provider.URL
+	rr := submitForm(ui, cookie, token, applicantForm())
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusBadGateway))
+	g.Expect(rr.Body.String()).To(gomega.ContainSubstring(`<p>unable to retrieve CSCards. Please try again in a moment.</p>`))
+}
+
+//TestUIEscaping checks card details from a provider cannot inject markup or script links into the page
+func TestUIEscaping(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	stop := startFakeProviders(`[{"cardName": "<script>alert(1)</script>", "url": "javascript:alert(1)", "apr": 20, "eligibility": 5}]`, `[]`)
+	defer stop()
+	ui, err := NewUI("templates")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cookie, token := loadForm(g, ui)
+
+	rr := submitForm(ui, cookie, token, applicantForm())
+	g.Expect(rr.Code).To(gomega.Equal(http.StatusOK))
+	g.Expect(rr.Body.String()).NotTo(gomega.ContainSubstring("<script>"))
+	g.Expect(rr.Body.String()).NotTo(gomega.ContainSubstring(`href="javascript:`))
+	g.Expect(rr.Body.String()).To(gomega.ContainSubstring("&lt;script&gt;alert(1)&lt;/script&gt;"))
+}
+
+//TestUIRoutes checks the form is only served when the API does not ask for authentication, and keeps its CSRF check and rate limit
+func TestUIRoutes(t *testing.T) {
+	//test tool
+	g := gomega.NewGomegaWithT(t)
+
+	open := DefaultConfig()
+	open.RateLimit.Tiers["standard"] = RateLimitTier{RequestsPerMinute: 1, Burst: 2}
+	openRouter, stopOpen, err := NewRouter(open)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer stopOpen()
+
+	authenticated := DefaultConfig()
+	authenticated.Auth = AuthConfig{Enabled: true, Keys: []APIKey{{Hash: HashAPIKey("secret"), Client: "billing", Routes: []string{"*"}}}}
+	authenticatedRouter, stopAuthenticated, err := NewRouter(authenticated)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer stopAuthenticated()
+
+	//makes test cases with struct in order to iterate instead of repeating
+	tests := []struct {
+		Message string
+		Router  http.Handler
+		Method  string
+		Path    string
+		Status  int
+	}{
+		{Message: "should serve the form without authentication", Router: openRouter, Method: http.MethodGet, Path: "/", Status: http.StatusOK},
+		{Message: "should check the CSRF token of a submitted form", Router: openRouter, Method: http.MethodPost, Path: "/", Status: http.StatusForbidden},
+		{Message: "should limit the address the pages are asked from", Router: openRouter, Method: http.MethodGet, Path: "/", Status: http.StatusTooManyRequests},
+		{Message: "should not serve the form as clients authenticate", Router: authenticatedRouter, Method: http.MethodGet, Path: "/", Status: http.StatusNotFound},
+		{Message: "should not recommend from a submitted form as clients authenticate", Router: authenticatedRouter, Method: http.MethodPost, Path: "/", Status: http.StatusNotFound},
+		{Message: "should not stream from the form as clients authenticate", Router: authenticatedRouter, Method: http.MethodPost, Path: "/stream", Status: http.StatusNotFound},
+		{Message: "should not serve the scripts as clients authenticate", Router: authenticatedRouter, Method: http.MethodGet, Path: "/static/stream.js", Status: http.StatusNotFound},
+		{Message: "should ask the API for an api key", Router: authenticatedRouter, Method: http.MethodPost, Path: "/v1/creditcard", Status: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.Message, func(t *testing.T) {
+			req := httptest.NewRequest(test.Method, test.Path, nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			rr := httptest.NewRecorder()
+			test.Router.ServeHTTP(rr, req)
+			g.Expect(rr.Code).To(gomega.Equal(test.Status))
+		})
+	}
+}